@@ -0,0 +1,266 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-kit/log"
+
+	"github.com/mm-dict/pearl-exporter/config"
+)
+
+// checkThresholds are the conditions evaluated by the check command. Zero
+// thresholds and empty lists are not checked.
+type checkThresholds struct {
+	storageFreeWarning     float64
+	storageFreeCritical    float64
+	cpuTemperatureWarning  float64
+	cpuTemperatureCritical float64
+	// signal lists the channels that must have a video signal, "*" for all.
+	signal []string
+	// recorders lists the recorders that must be recording.
+	recorders []string
+	// audio lists the sources that must not be silent.
+	audio []string
+}
+
+// collectors returns the collectors needed to evaluate the thresholds.
+func (t checkThresholds) collectors() []string {
+	var names []string
+	if t.storageFreeWarning > 0 || t.storageFreeCritical > 0 {
+		names = append(names, "storage")
+	}
+	if t.cpuTemperatureWarning > 0 || t.cpuTemperatureCritical > 0 {
+		names = append(names, "thermal")
+	}
+	if len(t.signal) > 0 {
+		names = append(names, "channels")
+	}
+	if len(t.recorders) > 0 {
+		names = append(names, "recorders")
+	}
+	if len(t.audio) > 0 {
+		names = append(names, "audio")
+	}
+	return names
+}
+
+var checkStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkResult is the outcome of a check in the plugin output format.
+type checkResult struct {
+	state    int
+	problems []string
+	perfdata []string
+}
+
+// raise records a problem and makes the state at least as severe.
+// Critical outranks warning, which outranks unknown.
+func (r *checkResult) raise(state int, format string, args ...interface{}) {
+	severity := map[int]int{exitOK: 0, exitUnknown: 1, exitWarning: 2, exitCritical: 3}
+	if severity[state] > severity[r.state] {
+		r.state = state
+	}
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r checkResult) write(w io.Writer, summary string) error {
+	text := summary
+	if len(r.problems) > 0 {
+		text = strings.Join(r.problems, ", ")
+	}
+	line := fmt.Sprintf("PEARL %s - %s", checkStates[r.state], text)
+	if len(r.perfdata) > 0 {
+		line += " | " + strings.Join(r.perfdata, " ")
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// collector returns the report of the named collector.
+func (r probeReport) collector(name string) (collectorReport, bool) {
+	for _, c := range r.Collectors {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return collectorReport{}, false
+}
+
+// value returns the value of the first sample with the given name whose
+// labels include the given ones.
+func (c collectorReport) value(name string, labels map[string]string) (float64, bool) {
+	for _, s := range c.Samples {
+		if s.Name != name {
+			continue
+		}
+		matched := true
+		for k, v := range labels {
+			if s.Labels[k] != v {
+				matched = false
+			}
+		}
+		if matched {
+			return s.Value, true
+		}
+	}
+	return 0, false
+}
+
+// usable reports whether the collector ran, raising unknown when it failed.
+// Collectors skipped for the model of the device are not checked.
+func (r *checkResult) usable(report probeReport, name string) (collectorReport, bool) {
+	c, ok := report.collector(name)
+	if !ok || c.Status == "skipped" {
+		return c, false
+	}
+	if c.Status == "failed" {
+		r.raise(exitUnknown, "%s: %s", name, c.Error)
+		return c, false
+	}
+	return c, true
+}
+
+func evaluateCheck(report probeReport, t checkThresholds) checkResult {
+	result := checkResult{}
+	if !report.Success {
+		result.raise(exitCritical, "unable to probe device: %s", report.Error)
+		return result
+	}
+	result.perfdata = append(result.perfdata, fmt.Sprintf("'probe_duration'=%gs", report.DurationSeconds))
+
+	if c, ok := result.usable(report, "storage"); ok {
+		total, _ := c.value("pearl_storage", map[string]string{"type": "total"})
+		free, hasFree := c.value("pearl_storage", map[string]string{"type": "free"})
+		if (total <= 0 || !hasFree) && (t.storageFreeWarning > 0 || t.storageFreeCritical > 0) {
+			result.raise(exitUnknown, "storage size not reported")
+		}
+		if total > 0 && hasFree {
+			percent := free / total * 100
+			switch {
+			case t.storageFreeCritical > 0 && percent < t.storageFreeCritical:
+				result.raise(exitCritical, "%.1f%% storage free", percent)
+			case t.storageFreeWarning > 0 && percent < t.storageFreeWarning:
+				result.raise(exitWarning, "%.1f%% storage free", percent)
+			}
+			result.perfdata = append(result.perfdata, fmt.Sprintf("'storage_free'=%.1f%%;%s;%s;0;100", percent, perfThreshold(t.storageFreeWarning), perfThreshold(t.storageFreeCritical)))
+		}
+	}
+
+	if c, ok := result.usable(report, "thermal"); ok {
+		temperature, ok := c.value("pearl_cpu_temperature_celsius", nil)
+		if !ok && (t.cpuTemperatureWarning > 0 || t.cpuTemperatureCritical > 0) {
+			result.raise(exitUnknown, "CPU temperature not reported")
+		}
+		if ok {
+			switch {
+			case t.cpuTemperatureCritical > 0 && temperature > t.cpuTemperatureCritical:
+				result.raise(exitCritical, "CPU temperature %g°C", temperature)
+			case t.cpuTemperatureWarning > 0 && temperature > t.cpuTemperatureWarning:
+				result.raise(exitWarning, "CPU temperature %g°C", temperature)
+			}
+			result.perfdata = append(result.perfdata, fmt.Sprintf("'cpu_temperature'=%g;%s;%s", temperature, perfThreshold(t.cpuTemperatureWarning), perfThreshold(t.cpuTemperatureCritical)))
+		}
+	}
+
+	if c, ok := result.usable(report, "channels"); ok && len(t.signal) > 0 {
+		channels := t.signal
+		if len(channels) == 1 && channels[0] == "*" {
+			channels = nil
+			for _, s := range c.Samples {
+				if s.Name == "pearl_channels_info" && s.Labels["type"] == "nosignal" {
+					channels = append(channels, s.Labels["id"])
+				}
+			}
+		}
+		for _, channel := range channels {
+			noSignal, ok := c.value("pearl_channels_info", map[string]string{"id": channel, "type": "nosignal"})
+			switch {
+			case !ok:
+				result.raise(exitUnknown, "channel %s not found", channel)
+			case noSignal != 0:
+				result.raise(exitCritical, "channel %s has no signal", channel)
+			}
+			if bitrate, ok := c.value("pearl_channels_info", map[string]string{"id": channel, "type": "bitrate"}); ok {
+				result.perfdata = append(result.perfdata, fmt.Sprintf("'channel_%s_bitrate'=%g", channel, bitrate))
+			}
+		}
+	}
+
+	if c, ok := result.usable(report, "recorders"); ok {
+		for _, recorder := range t.recorders {
+			recording, ok := c.value("pearl_recorder_info", map[string]string{"id": recorder})
+			switch {
+			case !ok:
+				result.raise(exitUnknown, "recorder %s not found", recorder)
+			case recording == 0:
+				result.raise(exitCritical, "recorder %s is not recording", recorder)
+			}
+		}
+	}
+
+	if c, ok := result.usable(report, "audio"); ok {
+		for _, source := range t.audio {
+			silent, ok := c.value("pearl_audio_silent", map[string]string{"source": source})
+			switch {
+			case !ok:
+				result.raise(exitUnknown, "audio source %s not found", source)
+			case silent != 0:
+				result.raise(exitCritical, "audio source %s is silent", source)
+			}
+		}
+	}
+	return result
+}
+
+func perfThreshold(threshold float64) string {
+	if threshold == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g", threshold)
+}
+
+// runCheckCommand probes the target with the collectors needed for the
+// thresholds and prints the result as a Nagios/Icinga plugin.
+func runCheckCommand(target string, user string, passwordFile string, t checkThresholds, c *config.Config, logger log.Logger) int {
+	user, password, err := commandCredentials(target, user, passwordFile, c)
+	if err != nil {
+		fmt.Printf("PEARL UNKNOWN - %s\n", err)
+		return exitUnknown
+	}
+	names := t.collectors()
+	if len(names) == 0 {
+		names = commandCollectors(false)
+	}
+	report := probeOnce(target, user, password, names, c, logger)
+	result := evaluateCheck(report, t)
+	summary := fmt.Sprintf("%s %s, firmware %s", report.Model, report.Serial, report.FirmwareVersion)
+	if err := result.write(os.Stdout, summary); err != nil {
+		return exitUnknown
+	}
+	return result.state
+}
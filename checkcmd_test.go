@@ -0,0 +1,127 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEvaluateCheck(t *testing.T) {
+	storage := func(total, free float64) collectorReport {
+		return collectorReport{Name: "storage", Status: "success", Samples: []sampleReport{
+			{Name: "pearl_storage", Labels: map[string]string{"type": "total"}, Value: total},
+			{Name: "pearl_storage", Labels: map[string]string{"type": "free"}, Value: free},
+		}}
+	}
+	thermal := func(temperature float64) collectorReport {
+		return collectorReport{Name: "thermal", Status: "success", Samples: []sampleReport{
+			{Name: "pearl_cpu_temperature_celsius", Value: temperature},
+		}}
+	}
+	channels := collectorReport{Name: "channels", Status: "success", Samples: []sampleReport{
+		{Name: "pearl_channels_info", Labels: map[string]string{"id": "1", "type": "nosignal"}, Value: 0},
+		{Name: "pearl_channels_info", Labels: map[string]string{"id": "2", "type": "nosignal"}, Value: 1},
+	}}
+	limits := checkThresholds{storageFreeWarning: 20, storageFreeCritical: 10, cpuTemperatureWarning: 70, cpuTemperatureCritical: 80}
+	tests := []struct {
+		name       string
+		collectors []collectorReport
+		thresholds checkThresholds
+		state      int
+		problem    string
+	}{
+		{"storage above warning", []collectorReport{storage(100, 21)}, limits, exitOK, ""},
+		{"storage at warning", []collectorReport{storage(100, 20)}, limits, exitOK, ""},
+		{"storage below warning", []collectorReport{storage(100, 19.9)}, limits, exitWarning, "19.9% storage free"},
+		{"storage at critical", []collectorReport{storage(100, 10)}, limits, exitWarning, "10.0% storage free"},
+		{"storage below critical", []collectorReport{storage(100, 9.9)}, limits, exitCritical, "9.9% storage free"},
+		{"storage full", []collectorReport{storage(100, 0)}, limits, exitCritical, "0.0% storage free"},
+		{"storage size missing", []collectorReport{{Name: "storage", Status: "success"}}, limits, exitUnknown, "storage size not reported"},
+		{"storage size missing without thresholds", []collectorReport{{Name: "storage", Status: "success"}}, checkThresholds{}, exitOK, ""},
+		{"storage thresholds off", []collectorReport{storage(100, 0)}, checkThresholds{}, exitOK, ""},
+		{"temperature below warning", []collectorReport{thermal(69.9)}, limits, exitOK, ""},
+		{"temperature at warning", []collectorReport{thermal(70)}, limits, exitOK, ""},
+		{"temperature above warning", []collectorReport{thermal(70.1)}, limits, exitWarning, "CPU temperature 70.1°C"},
+		{"temperature at critical", []collectorReport{thermal(80)}, limits, exitWarning, "CPU temperature 80°C"},
+		{"temperature above critical", []collectorReport{thermal(80.1)}, limits, exitCritical, "CPU temperature 80.1°C"},
+		{"temperature missing", []collectorReport{{Name: "thermal", Status: "success"}}, limits, exitUnknown, "CPU temperature not reported"},
+		{"only critical set", []collectorReport{thermal(75)}, checkThresholds{cpuTemperatureCritical: 80}, exitOK, ""},
+		{"collector failed", []collectorReport{{Name: "storage", Status: "failed", Error: "timeout"}}, limits, exitUnknown, "storage: timeout"},
+		{"collector skipped", []collectorReport{{Name: "thermal", Status: "skipped"}}, limits, exitOK, ""},
+		{"critical outranks unknown", []collectorReport{storage(100, 5), {Name: "thermal", Status: "failed", Error: "timeout"}}, limits, exitCritical, "5.0% storage free"},
+		{"signal", []collectorReport{channels}, checkThresholds{signal: []string{"1"}}, exitOK, ""},
+		{"no signal", []collectorReport{channels}, checkThresholds{signal: []string{"*"}}, exitCritical, "channel 2 has no signal"},
+		{"unknown channel", []collectorReport{channels}, checkThresholds{signal: []string{"3"}}, exitUnknown, "channel 3 not found"},
+	}
+	for _, test := range tests {
+		report := probeReport{Success: true, Collectors: test.collectors}
+		result := evaluateCheck(report, test.thresholds)
+		if result.state != test.state {
+			t.Errorf("%s: got state %s, want %s: %v", test.name, checkStates[result.state], checkStates[test.state], result.problems)
+		}
+		if test.problem != "" && !containsProblem(result.problems, test.problem) {
+			t.Errorf("%s: got problems %v, want %q", test.name, result.problems, test.problem)
+		}
+		if test.problem == "" && len(result.problems) > 0 {
+			t.Errorf("%s: got problems %v, want none", test.name, result.problems)
+		}
+	}
+
+	result := evaluateCheck(probeReport{Error: "connection refused"}, limits)
+	if result.state != exitCritical {
+		t.Errorf("failed probe: got state %s, want CRITICAL", checkStates[result.state])
+	}
+}
+
+func containsProblem(problems []string, problem string) bool {
+	for _, p := range problems {
+		if p == problem {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckResultWrite(t *testing.T) {
+	tests := []struct {
+		result checkResult
+		want   string
+	}{
+		{checkResult{}, "PEARL OK - all checks passed\n"},
+		{checkResult{perfdata: []string{"'probe_duration'=0.5s"}}, "PEARL OK - all checks passed | 'probe_duration'=0.5s\n"},
+		{checkResult{state: exitWarning, problems: []string{"a", "b"}}, "PEARL WARNING - a, b\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.result.write(&buf, "all checks passed"); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("write() = %q, want %q", got, test.want)
+		}
+	}
+	if perfThreshold(0) != "" || perfThreshold(80) != "80" {
+		t.Errorf("perfThreshold() = %q, %q, want \"\", \"80\"", perfThreshold(0), perfThreshold(80))
+	}
+}
@@ -0,0 +1,74 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/prober"
+)
+
+// runCheckConfigCommand validates a config file, printing every problem
+// found, and optionally connects to every configured target.
+func runCheckConfigCommand(file string, testConnect bool) int {
+	c, err := config.LoadFile(file)
+	if err != nil {
+		var errs config.Errors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Fprintf(os.Stdout, "%s: %s\n", file, e)
+			}
+		} else {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", file, err)
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stdout, "%s: OK\n", file)
+	if !testConnect {
+		return 0
+	}
+
+	prober.TLSConfig = c.TLS
+	targets := make([]string, 0, len(c.Targets))
+	for target := range c.Targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	code := 0
+	for _, target := range targets {
+		user, password := c.Credentials(target)
+		version, err := prober.GetFirmwareVersion(target, user, password)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", target, err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s: OK, firmware %s\n", target, version.Result)
+	}
+	return code
+}
@@ -0,0 +1,1049 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/prober"
+	"github.com/mm-dict/pearl-exporter/schedule"
+)
+
+// probe holds what collectors need to know about the target being probed.
+// Channel and source lists are fetched once and shared between collectors.
+type probe struct {
+	target          string
+	user            string
+	password        string
+	firmwareVersion string
+	device          *prober.DeviceInfo
+	profile         *prober.ModelProfile
+	config          *config.Config
+	logger          log.Logger
+	// maintenance is set when the target is in a maintenance window.
+	maintenance bool
+
+	systemStatus      *prober.SystemStatus
+	systemStatusError error
+	systemStatusStart time.Time
+	systemStatusEnd   time.Time
+
+	recorderList      *prober.RecorderStatus
+	recorderListError error
+
+	channelList      *prober.ChannelStatus
+	channelListError error
+	sourceList       []prober.SourceListDetails
+	sourceListError  error
+}
+
+// system returns the system status of the target. The time the request was
+// sent and answered is kept to relate the device date to the local clock.
+func (p *probe) system() (*prober.SystemStatus, error) {
+	if p.systemStatus == nil && p.systemStatusError == nil {
+		p.systemStatusStart = time.Now()
+		p.systemStatus, p.systemStatusError = prober.GetSystemInfo(p.target, p.user, p.password)
+		p.systemStatusEnd = time.Now()
+	}
+	return p.systemStatus, p.systemStatusError
+}
+
+// recorders returns the status of all recorders of the target.
+func (p *probe) recorders() (*prober.RecorderStatus, error) {
+	if p.recorderList == nil && p.recorderListError == nil {
+		p.recorderList, p.recorderListError = prober.GetRecorderInfo(p.target, p.user, p.password)
+	}
+	return p.recorderList, p.recorderListError
+}
+
+// channels returns the status of all channels of the target.
+func (p *probe) channels() (*prober.ChannelStatus, error) {
+	if p.channelList == nil && p.channelListError == nil {
+		p.channelList, p.channelListError = prober.GetChannelInfo(p.target, p.user, p.password)
+	}
+	return p.channelList, p.channelListError
+}
+
+// sources returns the inputs of the target, from the model profile when the
+// model is known and from the device otherwise.
+func (p *probe) sources() ([]prober.SourceListDetails, error) {
+	if len(p.profile.Sources) > 0 {
+		return p.profile.Sources, nil
+	}
+	if p.sourceList == nil && p.sourceListError == nil {
+		var list *prober.SourceList
+		list, p.sourceListError = prober.GetSources(p.target, p.user, p.password)
+		if p.sourceListError == nil {
+			p.sourceList = list.Result
+		}
+	}
+	return p.sourceList, p.sourceListError
+}
+
+// audioSourceIds returns the ids of the audio sources collected by the audio
+// collector, or nil if they are unknown.
+func (p *probe) audioSourceIds() []string {
+	sources, err := p.sources()
+	if err != nil {
+		return nil
+	}
+	ids := []string{}
+	for _, source := range sources {
+		if source.Audio {
+			ids = append(ids, source.Id)
+		}
+	}
+	return ids
+}
+
+// notProvided reports whether a request failed because the device does not
+// provide the endpoint at all, as opposed to failing to answer.
+func notProvided(err error) bool {
+	var apiErr *prober.APIError
+	return errors.Is(err, prober.ErrUnsupported) || (errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound)
+}
+
+// warnUnlessMaintenance logs failures at warning level, or at debug level for
+// targets in maintenance whose failures are expected.
+func warnUnlessMaintenance(logger log.Logger, maintenance bool) log.Logger {
+	if maintenance {
+		return level.Debug(logger)
+	}
+	return level.Warn(logger)
+}
+
+// collector gathers one group of metrics from the target into the registry.
+type collector struct {
+	name    string
+	collect func(p *probe, registry *prometheus.Registry) error
+}
+
+// probeCollectors lists every collector in the order they run. Model profiles
+// select which of them apply to a device. The firmware collector runs before
+// the system collector, which reports the result of its update check.
+var probeCollectors = []collector{
+	{"firmware", collectFirmware},
+	{"system", collectSystem},
+	{"thermal", collectThermal},
+	{"clock", collectClock},
+	{"network", collectNetwork},
+	{"storage", collectStorage},
+	{"storages", collectStorages},
+	{"channels", collectChannels},
+	{"channel_config", collectChannelConfig},
+	{"channel_preview", collectChannelPreview},
+	{"recorders", collectRecorders},
+	{"cms", collectCms},
+	{"schedule", collectSchedule},
+	{"sources", collectSources},
+	{"audio", collectAudio},
+}
+
+// collectorResult is the outcome of running a collector.
+type collectorResult struct {
+	name     string
+	skipped  bool
+	duration time.Duration
+	err      error
+}
+
+// newProbe prepares probing the target. It reads the firmware version, which
+// tells whether the device can be reached at all, selects the API decoders
+// for it and detects the device model.
+func newProbe(target string, user string, password string, c *config.Config, logger log.Logger) (*probe, error) {
+	firmwareVersion, err := prober.GetFirmwareVersion(target, user, password)
+	if err != nil {
+		// The device may have been replaced while it was unreachable.
+		modelDetector.Forget(target)
+		return nil, err
+	}
+	prober.Compatibility.Negotiate(target, firmwareVersion.Result)
+
+	device, profile, err := modelDetector.Detect(target, user, password)
+	if err != nil {
+		level.Warn(logger).Log("msg", "Unable to detect device model, trying all collectors", "target", target, "err", err)
+	}
+	return &probe{
+		target:          target,
+		user:            user,
+		password:        password,
+		firmwareVersion: firmwareVersion.Result,
+		device:          device,
+		profile:         profile,
+		config:          c,
+		logger:          logger,
+	}, nil
+}
+
+// runCollector runs a single collector if the model of the target supports it.
+func runCollector(c collector, p *probe, registry *prometheus.Registry) collectorResult {
+	if !p.profile.HasCollector(c.name) {
+		return collectorResult{name: c.name, skipped: true}
+	}
+	start := time.Now()
+	err := c.collect(p, registry)
+	return collectorResult{name: c.name, duration: time.Since(start), err: err}
+}
+
+// runCollectors runs every collector supported by the model of the target.
+func runCollectors(p *probe, registry *prometheus.Registry) {
+	for _, c := range probeCollectors {
+		if result := runCollector(c, p, registry); result.err != nil {
+			warnUnlessMaintenance(p.logger, p.maintenance).Log("msg", "Collector failed", "collector", c.name, "target", p.target, "err", result.err)
+		}
+	}
+}
+
+func collectSystem(p *probe, registry *prometheus.Registry) error {
+	probeInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "system_info",
+		Help:      "Returns system info for the probed device",
+	}, []string{"firmware_version", "firmware_update_availability", "uptime"})
+	probeCpuGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cpu_info",
+		Help:      "Returns information regarding the systems cpu load and temperature (deprecated, use pearl_cpu_load_ratio and pearl_cpu_load_high)",
+	}, []string{"type"})
+	probeCpuTempGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cpu_temp",
+		Help:      "Current temperature for the CPU (deprecated, use pearl_cpu_temperature_celsius)",
+	})
+	registry.MustRegister(probeInfoGauge)
+	registry.MustRegister(probeCpuGauge)
+
+	systemInfo, err := p.system()
+	if err != nil {
+		return err
+	}
+	// Only the firmware collector asks the device to check for updates, the
+	// result of its last check is reported here.
+	updateStatus := "disabled"
+	if p.config.Target(p.target).FirmwareUpdateCheckEnabled() {
+		updateStatus = "unknown"
+		if check := firmwareUpdateChecker.Cached(p.target); check != nil {
+			updateStatus = check.Status()
+		}
+	}
+	probeInfoGauge.With(prometheus.Labels{"firmware_version": p.firmwareVersion, "firmware_update_availability": updateStatus, "uptime": strconv.FormatInt(int64(systemInfo.Result.Uptime), 10)}).Set(1)
+	// Fields the device leaves out are not reported.
+	if systemInfo.Result.CpuLoad != nil {
+		probeCpuGauge.WithLabelValues("load").Set(float64(*systemInfo.Result.CpuLoad))
+	}
+	if systemInfo.Result.CpuLoadHigh != nil {
+		probeCpuGauge.WithLabelValues("load_high").Set(float64(prober.Bool2int(*systemInfo.Result.CpuLoadHigh)))
+	}
+	if systemInfo.Result.Cputemp != nil {
+		registry.MustRegister(probeCpuTempGauge)
+		probeCpuTempGauge.Set(float64(*systemInfo.Result.Cputemp))
+	}
+	return nil
+}
+
+func collectThermal(p *probe, registry *prometheus.Registry) error {
+	probeCpuLoadGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cpu_load_ratio",
+		Help:      "Returns the CPU load of the device between 0 and 1",
+	})
+	probeCpuLoadHighGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cpu_load_high",
+		Help:      "Returns whether the device reports its CPU load as high",
+	})
+	probeCpuTemperatureGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cpu_temperature_celsius",
+		Help:      "Returns the CPU temperature of the device",
+	})
+	probeTemperatureGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "temperature_celsius",
+		Help:      "Returns the temperature measured by a sensor of the device",
+	}, []string{"sensor", "name"})
+	probeFanSpeedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fan_speed_rpm",
+		Help:      "Returns the speed of a fan of the device",
+	}, []string{"sensor", "name"})
+	probeThermalAlarmGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "thermal_alarm",
+		Help:      "Returns whether a sensor is past its configured warning threshold",
+	}, []string{"sensor"})
+	registry.MustRegister(probeTemperatureGauge)
+	registry.MustRegister(probeFanSpeedGauge)
+	registry.MustRegister(probeThermalAlarmGauge)
+
+	thresholds := p.config.ThermalThresholds(p.target)
+	systemInfo, err := p.system()
+	if err != nil {
+		return err
+	}
+	// Gauges of fields the device leaves out are not registered, so no
+	// zero values are reported for them.
+	if systemInfo.Result.CpuLoad != nil {
+		registry.MustRegister(probeCpuLoadGauge)
+		probeCpuLoadGauge.Set(float64(*systemInfo.Result.CpuLoad) / 100)
+	}
+	if systemInfo.Result.CpuLoadHigh != nil {
+		registry.MustRegister(probeCpuLoadHighGauge)
+		probeCpuLoadHighGauge.Set(float64(prober.Bool2int(*systemInfo.Result.CpuLoadHigh)))
+	}
+	if cpuTemp := systemInfo.Result.Cputemp; cpuTemp != nil {
+		registry.MustRegister(probeCpuTemperatureGauge)
+		probeCpuTemperatureGauge.Set(float64(*cpuTemp))
+		if thresholds.CPUTemperatureCelsius != 0 {
+			probeThermalAlarmGauge.WithLabelValues("cpu").Set(float64(prober.Bool2int(float64(*cpuTemp) > thresholds.CPUTemperatureCelsius)))
+		}
+	}
+
+	sensors, err := prober.GetSensors(p.target, p.user, p.password)
+	if err != nil {
+		// Only some models have sensors besides the CPU temperature.
+		if notProvided(err) {
+			return nil
+		}
+		return err
+	}
+	for _, sensor := range sensors.Result {
+		switch sensor.Type {
+		case "temperature":
+			probeTemperatureGauge.WithLabelValues(sensor.Id, sensor.Name).Set(sensor.Value)
+			if thresholds.SensorTemperatureCelsius != 0 {
+				probeThermalAlarmGauge.WithLabelValues(sensor.Id).Set(float64(prober.Bool2int(sensor.Value > thresholds.SensorTemperatureCelsius)))
+			}
+		case "fan":
+			probeFanSpeedGauge.WithLabelValues(sensor.Id, sensor.Name).Set(sensor.Value)
+			if thresholds.FanMinimumRPM != 0 {
+				probeThermalAlarmGauge.WithLabelValues(sensor.Id).Set(float64(prober.Bool2int(sensor.Value < thresholds.FanMinimumRPM)))
+			}
+		}
+	}
+	return nil
+}
+
+func collectClock(p *probe, registry *prometheus.Registry) error {
+	probeClockOffsetGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "clock_offset_seconds",
+		Help:      "Returns how far the device clock is ahead of the exporter clock, corrected for the request round trip",
+	})
+	probeClockRoundTripGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "clock_round_trip_seconds",
+		Help:      "Returns the round trip time of the request the clock offset was measured with",
+	})
+	registry.MustRegister(probeClockOffsetGauge)
+	registry.MustRegister(probeClockRoundTripGauge)
+
+	systemInfo, err := p.system()
+	if err != nil {
+		return err
+	}
+	offset, err := prober.ClockOffset(systemInfo.Result.Date, p.systemStatusStart, p.systemStatusEnd)
+	if err != nil {
+		return err
+	}
+	probeClockOffsetGauge.Set(offset.Seconds())
+	probeClockRoundTripGauge.Set(p.systemStatusEnd.Sub(p.systemStatusStart).Seconds())
+
+	dateTime, err := prober.GetDateTime(p.target, p.user, p.password)
+	if err != nil {
+		// Not every firmware reports NTP settings.
+		if notProvided(err) {
+			return nil
+		}
+		return err
+	}
+	probeNtpEnabledGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ntp_enabled",
+		Help:      "Returns whether the device synchronizes its clock with NTP",
+	})
+	probeNtpSynchronizedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ntp_synchronized",
+		Help:      "Returns whether the device clock is synchronized with its NTP server",
+	})
+	probeNtpInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ntp_info",
+		Help:      "Returns the NTP server and timezone configured on the device",
+	}, []string{"server", "timezone"})
+	registry.MustRegister(probeNtpInfoGauge)
+	// Gauges of fields the device leaves out are not registered.
+	if ntp := dateTime.Result.Ntp; ntp.Enabled != nil {
+		registry.MustRegister(probeNtpEnabledGauge)
+		probeNtpEnabledGauge.Set(float64(prober.Bool2int(*ntp.Enabled)))
+	}
+	if ntp := dateTime.Result.Ntp; ntp.Synchronized != nil {
+		registry.MustRegister(probeNtpSynchronizedGauge)
+		probeNtpSynchronizedGauge.Set(float64(prober.Bool2int(*ntp.Synchronized)))
+	}
+	probeNtpInfoGauge.WithLabelValues(dateTime.Result.Ntp.Server, dateTime.Result.Timezone).Set(1)
+	return nil
+}
+
+func collectFirmware(p *probe, registry *prometheus.Registry) error {
+	probeFirmwareUpdateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "firmware_update_info",
+		Help:      "Returns the result of the last firmware update check and the version available, if any",
+	}, []string{"status", "available_version"})
+	probeFirmwareUpdateCheckGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "firmware_update_last_check_timestamp_seconds",
+		Help:      "Returns when the device was last asked to check for firmware updates",
+	})
+	probeFirmwareCompliantGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "firmware_compliant",
+		Help:      "Returns whether the firmware version is approved by the policy for the device model",
+	})
+
+	record := firmwareRecord{
+		Target:          p.target,
+		FirmwareVersion: p.firmwareVersion,
+		LastProbe:       time.Now(),
+	}
+	if p.device != nil {
+		record.Model = p.device.Result.Product
+		record.Serial = p.device.Result.Serial
+	}
+	if policy := p.config.FirmwarePolicy(record.Model); policy != nil {
+		compliant := firmwareCompliant(policy, record.FirmwareVersion)
+		record.MinimumVersion = policy.MinimumVersion
+		record.ApprovedVersions = policy.Versions
+		record.Compliant = &compliant
+		registry.MustRegister(probeFirmwareCompliantGauge)
+		probeFirmwareCompliantGauge.Set(float64(prober.Bool2int(compliant)))
+	}
+
+	var err error
+	if p.config.Target(p.target).FirmwareUpdateCheckEnabled() {
+		registry.MustRegister(probeFirmwareUpdateGauge)
+		registry.MustRegister(probeFirmwareUpdateCheckGauge)
+		updateCheck := firmwareUpdateChecker.Check(p.target, p.user, p.password, time.Duration(p.config.Firmware.UpdateCheckInterval))
+		if updateCheck.Err != nil {
+			err = fmt.Errorf("firmware update check failed: %s", updateCheck.Err)
+		}
+		probeFirmwareUpdateGauge.With(prometheus.Labels{"status": updateCheck.Status(), "available_version": updateCheck.AvailableVersion()}).Set(1)
+		probeFirmwareUpdateCheckGauge.Set(float64(updateCheck.Timestamp.Unix()))
+		record.AvailableVersion = updateCheck.AvailableVersion()
+	}
+	fleetFirmware.update(record)
+	return err
+}
+
+func collectNetwork(p *probe, registry *prometheus.Registry) error {
+	probeNetworkUpGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "network_up",
+		Help:      "Returns whether the network interface has a link",
+	}, []string{"interface"})
+	probeNetworkSpeedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "network_speed_bytes",
+		Help:      "Returns the link speed of the network interface in bytes per second",
+	}, []string{"interface"})
+	probeNetworkInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "network_info",
+		Help:      "Returns the duplex mode and IPv4 configuration of the network interface",
+	}, []string{"interface", "duplex", "method", "address", "netmask", "gateway"})
+	probeNetworkReceiveBytesCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "network_receive_bytes_total",
+		Help:      "Returns the number of bytes received by the network interface",
+	}, []string{"interface"})
+	probeNetworkTransmitBytesCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "network_transmit_bytes_total",
+		Help:      "Returns the number of bytes transmitted by the network interface",
+	}, []string{"interface"})
+	probeNetworkReceiveErrsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "network_receive_errs_total",
+		Help:      "Returns the number of receive errors of the network interface",
+	}, []string{"interface"})
+	probeNetworkTransmitErrsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "network_transmit_errs_total",
+		Help:      "Returns the number of transmit errors of the network interface",
+	}, []string{"interface"})
+	registry.MustRegister(probeNetworkUpGauge)
+	registry.MustRegister(probeNetworkSpeedGauge)
+	registry.MustRegister(probeNetworkInfoGauge)
+	registry.MustRegister(probeNetworkReceiveBytesCounter)
+	registry.MustRegister(probeNetworkTransmitBytesCounter)
+	registry.MustRegister(probeNetworkReceiveErrsCounter)
+	registry.MustRegister(probeNetworkTransmitErrsCounter)
+
+	networkInfo, err := prober.GetNetworkInfo(p.target, p.user, p.password)
+	if err != nil {
+		return err
+	}
+	for _, nic := range networkInfo.Result {
+		method := "static"
+		if nic.Dhcp {
+			method = "dhcp"
+		}
+		probeNetworkUpGauge.WithLabelValues(nic.Id).Set(float64(prober.Bool2int(nic.Link)))
+		if nic.Speed != nil {
+			probeNetworkSpeedGauge.WithLabelValues(nic.Id).Set(float64(*nic.Speed) * 1000 * 1000 / 8)
+		}
+		probeNetworkInfoGauge.With(prometheus.Labels{"interface": nic.Id, "duplex": nic.Duplex, "method": method,
+			"address": nic.Ipv4.Address, "netmask": nic.Ipv4.Netmask, "gateway": nic.Ipv4.Gateway}).Set(1)
+		if nic.RxBytes != nil {
+			probeNetworkReceiveBytesCounter.WithLabelValues(nic.Id).Add(float64(*nic.RxBytes))
+		}
+		if nic.TxBytes != nil {
+			probeNetworkTransmitBytesCounter.WithLabelValues(nic.Id).Add(float64(*nic.TxBytes))
+		}
+		if nic.RxErrors != nil {
+			probeNetworkReceiveErrsCounter.WithLabelValues(nic.Id).Add(float64(*nic.RxErrors))
+		}
+		if nic.TxErrors != nil {
+			probeNetworkTransmitErrsCounter.WithLabelValues(nic.Id).Add(float64(*nic.TxErrors))
+		}
+	}
+	return nil
+}
+
+func collectStorage(p *probe, registry *prometheus.Registry) error {
+	probeStorageGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage",
+		Help:      "Returns the current status for the storage devices attached",
+	}, []string{"type"})
+	registry.MustRegister(probeStorageGauge)
+
+	storageInfo, err := prober.GetStorageInfo(p.target, p.user, p.password)
+	if err != nil {
+		return err
+	}
+	if storageInfo.Result.Total != nil {
+		probeStorageGauge.WithLabelValues("total").Add(float64(*storageInfo.Result.Total))
+	}
+	if storageInfo.Result.Free != nil {
+		probeStorageGauge.WithLabelValues("free").Add(float64(*storageInfo.Result.Free))
+	}
+	return nil
+}
+
+func collectStorages(p *probe, registry *prometheus.Registry) error {
+	probeStoragesGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storages",
+		Help:      "Returns the current status for every storage volume of devices with several volumes",
+	}, []string{"storage", "state", "type"})
+	registry.MustRegister(probeStoragesGauge)
+
+	storages, err := prober.GetStoragesInfo(p.target, p.user, p.password)
+	if err != nil {
+		return err
+	}
+	for _, storage := range storages.Result {
+		probeStoragesGauge.With(prometheus.Labels{"storage": storage.Id, "state": storage.State, "type": "total"}).Set(float64(storage.Total))
+		probeStoragesGauge.With(prometheus.Labels{"storage": storage.Id, "state": storage.State, "type": "free"}).Set(float64(storage.Free))
+	}
+	return nil
+}
+
+func collectChannels(p *probe, registry *prometheus.Registry) error {
+	probeChannelsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channels_info",
+		Help:      "Returns information regarding the configured channels and their publishers",
+	}, []string{"id", "status", "type"})
+	registry.MustRegister(probeChannelsGauge)
+
+	channelInfo, err := p.channels()
+	if err != nil {
+		return err
+	}
+	for key := range channelInfo.Result {
+		status := channelInfo.Result[key].Status
+		// Values the device leaves out are not reported.
+		for name, value := range map[string]*float64{"nosignal": status.Nosignal, "bitrate": status.Bitrate, "duration": status.Duration} {
+			if value != nil {
+				probeChannelsGauge.With(prometheus.Labels{"id": channelInfo.Result[key].Id,
+					"status": status.State, "type": name}).Set(*value)
+			}
+		}
+	}
+	return nil
+}
+
+func collectChannelConfig(p *probe, registry *prometheus.Registry) error {
+	probeChannelConfigGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_config_info",
+		Help:      "Returns the configured codec, resolution and active layout of the channel",
+	}, []string{"channel", "codec", "resolution", "layout"})
+	probeChannelFramerateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_config_framerate",
+		Help:      "Returns the configured framerate of the channel",
+	}, []string{"channel"})
+	probeChannelBitrateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_config_bitrate_kbps",
+		Help:      "Returns the configured target bitrate of the channel in kbit/s",
+	}, []string{"channel"})
+	probeChannelDriftGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_config_drift",
+		Help:      "Returns whether a channel setting differs from the expected channel profile",
+	}, []string{"channel", "field"})
+	registry.MustRegister(probeChannelConfigGauge)
+	registry.MustRegister(probeChannelFramerateGauge)
+	registry.MustRegister(probeChannelBitrateGauge)
+	registry.MustRegister(probeChannelDriftGauge)
+
+	channelInfo, err := p.channels()
+	if err != nil {
+		return err
+	}
+	for _, channel := range channelInfo.Result {
+		encoding, err := prober.GetChannelEncoding(p.target, p.user, p.password, channel.Id)
+		if err != nil {
+			level.Warn(p.logger).Log("msg", "Unable to read channel encoding", "target", p.target, "channel", channel.Id, "err", err)
+			continue
+		}
+		layout := ""
+		if layouts, err := prober.GetChannelLayouts(p.target, p.user, p.password, channel.Id); err == nil {
+			layout = layouts.ActiveLayout()
+		} else {
+			level.Warn(p.logger).Log("msg", "Unable to read channel layouts", "target", p.target, "channel", channel.Id, "err", err)
+		}
+		e := encoding.Result
+		probeChannelConfigGauge.With(prometheus.Labels{"channel": channel.Id, "codec": e.Codec, "resolution": e.Resolution, "layout": layout}).Set(1)
+		probeChannelFramerateGauge.WithLabelValues(channel.Id).Set(e.Framerate)
+		probeChannelBitrateGauge.WithLabelValues(channel.Id).Set(float64(e.Bitrate))
+		if profile := p.config.ChannelProfile(p.target, channel.Id); profile != nil {
+			for field, drift := range profile.Drift(e.Codec, e.Resolution, e.Framerate, e.Bitrate, layout) {
+				probeChannelDriftGauge.WithLabelValues(channel.Id, field).Set(float64(prober.Bool2int(drift)))
+			}
+		}
+	}
+	return nil
+}
+
+func collectChannelPreview(p *probe, registry *prometheus.Registry) error {
+	if !p.config.PreviewEnabled(p.target) {
+		return nil
+	}
+	probePreviewLuminanceGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_preview_luminance",
+		Help:      "Returns the average luminance of the channel preview image between 0 and 1",
+	}, []string{"channel"})
+	probePreviewBlackGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_preview_black",
+		Help:      "Returns whether the channel preview image is black",
+	}, []string{"channel"})
+	probePreviewFrozenGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_preview_frozen_seconds",
+		Help:      "Returns how long the channel preview image has not changed",
+	}, []string{"channel"})
+	registry.MustRegister(probePreviewLuminanceGauge)
+	registry.MustRegister(probePreviewBlackGauge)
+	registry.MustRegister(probePreviewFrozenGauge)
+
+	channelInfo, err := p.channels()
+	if err != nil {
+		return err
+	}
+	for _, channel := range channelInfo.Result {
+		preview, err := prober.GetChannelPreview(p.target, p.user, p.password, channel.Id)
+		if err != nil {
+			level.Warn(p.logger).Log("msg", "Unable to read channel preview", "target", p.target, "channel", channel.Id, "err", err)
+			continue
+		}
+		stats := previewAnalyzer.Analyze(p.target, channel.Id, preview, p.config.Preview.BlackLuminance, p.config.Preview.FrozenDifference)
+		probePreviewLuminanceGauge.WithLabelValues(channel.Id).Set(stats.Luminance)
+		probePreviewBlackGauge.WithLabelValues(channel.Id).Set(float64(prober.Bool2int(stats.Black)))
+		probePreviewFrozenGauge.WithLabelValues(channel.Id).Set(stats.FrozenSeconds)
+	}
+	return nil
+}
+
+func collectRecorders(p *probe, registry *prometheus.Registry) error {
+	probeRecorderGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "recorder_info",
+		Help:      "Returns information regarding the configured recorders",
+	}, []string{"id"})
+	registry.MustRegister(probeRecorderGauge)
+
+	recorderInfo, err := p.recorders()
+	if err != nil {
+		return err
+	}
+	for key := range recorderInfo.Result {
+		probeRecorderGauge.With(prometheus.Labels{"id": recorderInfo.Result[key].Id}).Set(float64(prober.Bool2int(recorderInfo.Result[key].Recording())))
+	}
+	return nil
+}
+
+func collectCms(p *probe, registry *prometheus.Registry) error {
+	probeCmsInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_info",
+		Help:      "Returns the type and registration state of the CMS integration",
+	}, []string{"type", "state"})
+	probeCmsEnabledGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_enabled",
+		Help:      "Returns whether the device receives its schedule from a CMS",
+	})
+	probeCmsRegisteredGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_registered",
+		Help:      "Returns whether the device is registered with the CMS",
+	})
+	probeCmsLastSyncGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_last_sync_timestamp_seconds",
+		Help:      "Returns when the device last synchronized successfully with the CMS",
+	})
+	probeCmsUpcomingGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_upcoming_events",
+		Help:      "Returns the number of scheduled events that have not started yet",
+	})
+	probeCmsNextEventGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_next_event_start_timestamp_seconds",
+		Help:      "Returns when the next scheduled event starts",
+	})
+	probeCmsEventRunningGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_event_running",
+		Help:      "Returns whether a scheduled event should currently be recording",
+	})
+	probeCmsEventNotRecordingGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cms_event_not_recording",
+		Help:      "Returns whether a scheduled event should currently be recording but its recorder is not",
+	})
+
+	cmsStatus, err := prober.GetCmsStatus(p.target, p.user, p.password)
+	if err != nil {
+		// Older firmware has no CMS integration.
+		if notProvided(err) {
+			return nil
+		}
+		return err
+	}
+	registry.MustRegister(probeCmsInfoGauge)
+	registry.MustRegister(probeCmsEnabledGauge)
+	probeCmsEnabledGauge.Set(float64(prober.Bool2int(cmsStatus.Result.Enabled)))
+	// Devices without a CMS are not reported as unregistered.
+	if !cmsStatus.Result.Enabled {
+		return nil
+	}
+	registry.MustRegister(probeCmsRegisteredGauge)
+	probeCmsInfoGauge.WithLabelValues(cmsStatus.Result.Type, cmsStatus.Result.State).Set(1)
+	probeCmsRegisteredGauge.Set(float64(prober.Bool2int(cmsStatus.Result.State == "registered")))
+	if cmsStatus.Result.LastSync != "" {
+		lastSync, err := prober.ParseDeviceDate(cmsStatus.Result.LastSync)
+		if err != nil {
+			return err
+		}
+		registry.MustRegister(probeCmsLastSyncGauge)
+		probeCmsLastSyncGauge.Set(float64(lastSync.Unix()))
+	}
+
+	events, err := prober.GetCmsEvents(p.target, p.user, p.password)
+	if err != nil {
+		return err
+	}
+	recorders, err := p.recorders()
+	if err != nil {
+		return err
+	}
+	registry.MustRegister(probeCmsUpcomingGauge)
+	registry.MustRegister(probeCmsEventRunningGauge)
+	registry.MustRegister(probeCmsEventNotRecordingGauge)
+
+	now := time.Now()
+	upcoming := 0
+	var next time.Time
+	running, notRecording := false, false
+	for _, event := range events.Result {
+		start, err := prober.ParseDeviceDate(event.Start)
+		if err != nil {
+			return fmt.Errorf("event %q: %s", event.Id, err)
+		}
+		end, err := prober.ParseDeviceDate(event.End)
+		if err != nil {
+			return fmt.Errorf("event %q: %s", event.Id, err)
+		}
+		switch {
+		case start.After(now):
+			upcoming++
+			if next.IsZero() || start.Before(next) {
+				next = start
+			}
+		case end.After(now):
+			running = true
+			recording := recorders.Recording()
+			if event.Recorder != "" {
+				recorder, ok := recorders.Recorder(event.Recorder)
+				recording = ok && recorder.Recording()
+			}
+			if !recording {
+				notRecording = true
+			}
+		}
+	}
+	probeCmsUpcomingGauge.Set(float64(upcoming))
+	probeCmsEventRunningGauge.Set(float64(prober.Bool2int(running)))
+	probeCmsEventNotRecordingGauge.Set(float64(prober.Bool2int(notRecording)))
+	if !next.IsZero() {
+		registry.MustRegister(probeCmsNextEventGauge)
+		probeCmsNextEventGauge.Set(float64(next.Unix()))
+	}
+	return nil
+}
+
+func collectSchedule(p *probe, registry *prometheus.Registry) error {
+	tc := p.config.Target(p.target)
+	if tc.Schedule == "" {
+		return nil
+	}
+	probeRecordingExpectedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "recording_expected",
+		Help:      "Returns whether the schedule of the device expects it to be recording",
+	})
+	probeRecordingMissedCounter := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recording_missed_total",
+		Help:      "Returns the number of scheduled recordings the device was not recording past the grace period",
+	})
+	probeRecordingUnexpectedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "recording_unexpected",
+		Help:      "Returns whether the device is recording while its schedule expects no recording",
+	})
+	registry.MustRegister(probeRecordingExpectedGauge)
+	registry.MustRegister(probeRecordingMissedCounter)
+	registry.MustRegister(probeRecordingUnexpectedGauge)
+
+	calendar, err := schedules.Load(tc.Schedule)
+	if err != nil {
+		return err
+	}
+	recorders, err := p.recorders()
+	if err != nil {
+		return err
+	}
+	recording := recorders.Recording()
+	if len(tc.ScheduleRecorders) > 0 {
+		recording = false
+		for _, id := range tc.ScheduleRecorders {
+			if recorder, ok := recorders.Recorder(id); ok && recorder.Recording() {
+				recording = true
+			}
+		}
+	}
+
+	now := time.Now()
+	grace := time.Duration(p.config.Schedule.GracePeriod)
+	active := calendar.Active(now, grace)
+	expected := false
+	for _, o := range active {
+		if !now.Before(o.Start) && now.Before(o.End) {
+			expected = true
+		}
+		if !recording && recordingMissed(o, now, grace) {
+			missedRecordings.Missed(p.target, o)
+		}
+	}
+	probeRecordingExpectedGauge.Set(float64(prober.Bool2int(expected)))
+	probeRecordingMissedCounter.Add(missedRecordings.Total(p.target))
+	probeRecordingUnexpectedGauge.Set(float64(prober.Bool2int(recording && len(active) == 0)))
+	return nil
+}
+
+// recordingMissed reports whether a recorder that is not recording at the
+// given time misses the scheduled occurrence. A recording only counts as
+// missed once the recorder has had the grace period to start, and before it
+// may have stopped early. For occurrences shorter than twice the grace
+// period, the grace period is shortened to a quarter of the occurrence, so
+// they are checked as well.
+func recordingMissed(o schedule.Occurrence, now time.Time, grace time.Duration) bool {
+	if d := o.End.Sub(o.Start); 2*grace > d {
+		grace = d / 4
+	}
+	return now.After(o.Start.Add(grace)) && now.Before(o.End.Add(-grace))
+}
+
+func collectSources(p *probe, registry *prometheus.Registry) error {
+	probeSDIStatusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sdi_status",
+		Help:      "Returns information regarding the SDI channel, sets the value to the current fps",
+	}, []string{"resolution"})
+	probeHDMIStatusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hdmi_status",
+		Help:      "Returns information regarding the HDMI channel, sets the value to the current fps",
+	}, []string{"resolution"})
+	probeSourceStateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_video_state",
+		Help:      "Returns the current signal state of the video source",
+	}, []string{"source", "state"})
+	probeSourceFpsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_fps",
+		Help:      "Returns the actual framerate of the video source signal",
+	}, []string{"source"})
+	probeSourceAudioStateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_audio_state",
+		Help:      "Returns the current signal state of the audio source",
+	}, []string{"source", "state"})
+	probeSourceInterlacedGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_interlaced",
+		Help:      "Returns whether the video source signal is interlaced",
+	}, []string{"source"})
+	probeSourceVrrGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_vrr",
+		Help:      "Returns the vertical refresh rate of the video source signal",
+	}, []string{"source"})
+	probeSourceWidthGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_width_pixels",
+		Help:      "Returns the horizontal resolution of the video source signal",
+	}, []string{"source"})
+	probeSourceHeightGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_height_pixels",
+		Help:      "Returns the vertical resolution of the video source signal",
+	}, []string{"source"})
+	probeSourceResolutionChangesCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_resolution_changes_total",
+		Help:      "Returns how often the resolution of the video source changed between probes",
+	}, []string{"source"})
+	registry.MustRegister(probeSDIStatusGauge)
+	registry.MustRegister(probeHDMIStatusGauge)
+	registry.MustRegister(probeSourceStateGauge)
+	registry.MustRegister(probeSourceFpsGauge)
+	registry.MustRegister(probeSourceAudioStateGauge)
+	registry.MustRegister(probeSourceInterlacedGauge)
+	registry.MustRegister(probeSourceVrrGauge)
+	registry.MustRegister(probeSourceWidthGauge)
+	registry.MustRegister(probeSourceHeightGauge)
+	registry.MustRegister(probeSourceResolutionChangesCounter)
+
+	sourceStatus, err := prober.GetSourceStatus(p.target, p.user, p.password, p.profile.SourceIds())
+	if err != nil {
+		return err
+	}
+	for _, source := range sourceStatus.Result {
+		if video := source.Status.Video; video != nil {
+			probeSourceStateGauge.With(prometheus.Labels{"source": source.Id, "state": video.State}).Set(1)
+			probeSourceFpsGauge.WithLabelValues(source.Id).Set(float64(video.Actual_fps))
+			probeSourceInterlacedGauge.WithLabelValues(source.Id).Set(float64(prober.Bool2int(video.Interlaced)))
+			probeSourceVrrGauge.WithLabelValues(source.Id).Set(float64(video.Vrr))
+			if width, height, ok := prober.ParseResolution(video.Resolution); ok {
+				probeSourceWidthGauge.WithLabelValues(source.Id).Set(float64(width))
+				probeSourceHeightGauge.WithLabelValues(source.Id).Set(float64(height))
+			}
+			probeSourceResolutionChangesCounter.WithLabelValues(source.Id).Add(resolutionTracker.Observe(p.target, source.Id, video.Resolution))
+		}
+		if audio := source.Status.Audio; audio != nil {
+			probeSourceAudioStateGauge.With(prometheus.Labels{"source": source.Id, "state": audio.State}).Set(1)
+		}
+	}
+
+	if sdi, ok := sourceStatus.Source("D2P0.sdi"); ok && sdi.Status.Video != nil {
+		probeSDIStatusGauge.With(prometheus.Labels{"resolution": sdi.Status.Video.Resolution}).Set(float64(sdi.Status.Video.Actual_fps))
+	}
+	if hdmi, ok := sourceStatus.Source("D2P0.hdmi-a"); ok && hdmi.Status.Video != nil {
+		probeHDMIStatusGauge.With(prometheus.Labels{"resolution": hdmi.Status.Video.Resolution}).Set(float64(hdmi.Status.Video.Actual_fps))
+	}
+	return nil
+}
+
+func collectAudio(p *probe, registry *prometheus.Registry) error {
+	probeAudioLevelGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "audio_level_dbfs",
+		Help:      "Returns the current peak and rms audio level in dBFS per channel of every audio source",
+	}, []string{"source", "channel", "type"})
+	probeAudioSilentGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "audio_silent",
+		Help:      "Returns whether the peak level of every channel of the audio source is below the silence threshold",
+	}, []string{"source"})
+	registry.MustRegister(probeAudioLevelGauge)
+	registry.MustRegister(probeAudioSilentGauge)
+
+	sources, err := p.sources()
+	if err != nil {
+		return err
+	}
+	silenceThreshold := p.config.SilenceThreshold(p.target)
+	for _, source := range sources {
+		if !source.Audio {
+			continue
+		}
+		audioLevels, err := prober.GetAudioLevels(p.target, p.user, p.password, source.Id)
+		if err != nil {
+			level.Warn(p.logger).Log("msg", "Unable to read audio levels", "target", p.target, "source", source.Id, "err", err)
+			continue
+		}
+		for channel, peak := range audioLevels.Result.Peak {
+			probeAudioLevelGauge.With(prometheus.Labels{"source": source.Id, "channel": strconv.Itoa(channel), "type": "peak"}).Set(peak)
+		}
+		for channel, rms := range audioLevels.Result.Rms {
+			probeAudioLevelGauge.With(prometheus.Labels{"source": source.Id, "channel": strconv.Itoa(channel), "type": "rms"}).Set(rms)
+		}
+		if len(audioLevels.Result.Peak) > 0 {
+			probeAudioSilentGauge.With(prometheus.Labels{"source": source.Id}).Set(float64(prober.Bool2int(audioLevels.Result.Silent(silenceThreshold))))
+		}
+	}
+	return nil
+}
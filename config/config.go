@@ -0,0 +1,926 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package config
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pearl_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Pearl exporter config loaded successfully.",
+	})
+
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pearl_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configReloadSuccess)
+	prometheus.MustRegister(configReloadSeconds)
+}
+
+// Config is the exporter configuration as read from the config file.
+type Config struct {
+	// Username and Password are the device credentials used for probes
+	// without credentials in their parameters and by the exporter itself,
+	// e.g. to act on alerts. Targets may override them. Probe requests only
+	// get them for targets listed under Targets or allowed by AllowedTargets.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is read into Password.
+	PasswordFile string `yaml:"password_file,omitempty"`
+
+	// TLSConfig configures connections to devices. Certificates are not
+	// verified if it is missing, as devices ship with self-signed ones.
+	TLSConfig *promconfig.TLSConfig `yaml:"tls_config,omitempty"`
+
+	// AllowedTargets restricts which devices may be probed. Entries are
+	// hostnames, shell-style hostname patterns (*.av.example.edu) or CIDR
+	// networks. Targets given by host name match networks by the addresses
+	// connected to. An empty list allows every target.
+	AllowedTargets []string `yaml:"allowed_targets,omitempty"`
+
+	Firmware FirmwareConfig `yaml:"firmware,omitempty"`
+
+	Audio AudioConfig `yaml:"audio,omitempty"`
+
+	Preview PreviewConfig `yaml:"preview,omitempty"`
+
+	Thermal ThermalConfig `yaml:"thermal,omitempty"`
+
+	Schedule ScheduleConfig `yaml:"schedule,omitempty"`
+
+	// ChannelProfiles declares named sets of expected channel encoding
+	// settings that targets refer to.
+	ChannelProfiles map[string]ChannelProfile `yaml:"channel_profiles,omitempty"`
+
+	Webhook WebhookConfig `yaml:"webhook,omitempty"`
+
+	Control ControlConfig `yaml:"control,omitempty"`
+
+	// MaintenanceWindows declares when devices are being serviced.
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenance_windows,omitempty"`
+
+	// Targets holds per-device settings keyed by the probe target.
+	Targets map[string]TargetConfig `yaml:"targets,omitempty"`
+
+	allowlist allowlist
+	tls       *tls.Config
+}
+
+// FirmwareConfig configures the firmware collectors.
+type FirmwareConfig struct {
+	// UpdateCheckInterval is how long the result of a firmware update check
+	// is reused before the device is asked to check again. Every check makes
+	// the device contact the vendor update server.
+	UpdateCheckInterval model.Duration `yaml:"update_check_interval,omitempty"`
+
+	// Approved lists the firmware policies devices are checked against. The
+	// first policy matching the device model applies.
+	Approved []FirmwarePolicy `yaml:"approved,omitempty"`
+}
+
+// FirmwarePolicy declares the approved firmware for a device model.
+type FirmwarePolicy struct {
+	// Model is matched case-insensitively against the product name reported
+	// by the device and may be a shell-style pattern. Empty matches any model.
+	Model string `yaml:"model,omitempty"`
+	// MinimumVersion approves this and every later version.
+	MinimumVersion string `yaml:"minimum_version,omitempty"`
+	// Versions approves exactly the listed versions.
+	Versions []string `yaml:"versions,omitempty"`
+}
+
+// AudioConfig configures the audio level collector.
+type AudioConfig struct {
+	// SilenceThresholdDBFS is the peak level below which every channel of a
+	// source must stay for the source to be reported silent.
+	SilenceThresholdDBFS *float64 `yaml:"silence_threshold_dbfs,omitempty"`
+}
+
+// PreviewConfig configures the channel preview collector, which downloads a
+// preview image of every channel on each probe to detect black and frozen
+// pictures.
+type PreviewConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// BlackLuminance is the average luminance, between 0 and 1, below which
+	// a preview is considered black.
+	BlackLuminance float64 `yaml:"black_luminance,omitempty"`
+	// FrozenDifference is the mean luminance difference to the previous
+	// preview below which the picture is considered unchanged.
+	FrozenDifference float64 `yaml:"frozen_difference,omitempty"`
+}
+
+// ThermalConfig holds the thresholds above which a thermal alarm is raised.
+// Zero thresholds are not checked.
+type ThermalConfig struct {
+	// CPUTemperatureCelsius is the warning threshold of the CPU temperature.
+	CPUTemperatureCelsius float64 `yaml:"cpu_temperature_celsius,omitempty"`
+	// SensorTemperatureCelsius is the warning threshold of the other
+	// temperature sensors of the device.
+	SensorTemperatureCelsius float64 `yaml:"sensor_temperature_celsius,omitempty"`
+	// FanMinimumRPM is the speed below which a fan is considered failing.
+	FanMinimumRPM float64 `yaml:"fan_minimum_rpm,omitempty"`
+}
+
+// ScheduleConfig configures the comparison of recorders with the iCalendar
+// schedules of targets.
+type ScheduleConfig struct {
+	// GracePeriod is how long a recorder may start late or stop early
+	// before a recording is counted as missed, and may record before or
+	// after a scheduled recording without being unexpected.
+	GracePeriod model.Duration `yaml:"grace_period,omitempty"`
+}
+
+// WebhookConfig configures the Alertmanager webhook receiver, which starts
+// and stops recorders when alerts fire or resolve.
+type WebhookConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// DryRun logs and counts the actions that would be taken without
+	// sending them to the devices.
+	DryRun bool `yaml:"dry_run,omitempty"`
+	// AllowedTargets restricts which devices the webhook may act on, in
+	// the format of the top-level allowed_targets. Targets must match both.
+	AllowedTargets []string `yaml:"allowed_targets,omitempty"`
+	// TargetLabel is the alert label holding the probe target.
+	TargetLabel string `yaml:"target_label,omitempty"`
+	// RecorderLabel is the alert label holding the recorder id.
+	RecorderLabel string `yaml:"recorder_label,omitempty"`
+	// Alerts lists the alerts acted upon by alert name.
+	Alerts []WebhookAlert `yaml:"alerts,omitempty"`
+	// AuditLog is the file every action, including those of a dry run, is
+	// appended to.
+	AuditLog string `yaml:"audit_log,omitempty"`
+
+	// Notifications must carry the bearer token or the basic auth
+	// credentials, set in the http_config of the Alertmanager receiver.
+	BearerToken     string            `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string            `yaml:"bearer_token_file,omitempty"`
+	BasicAuth       *WebhookBasicAuth `yaml:"basic_auth,omitempty"`
+
+	allowlist allowlist
+}
+
+// WebhookBasicAuth holds the basic auth credentials of the webhook.
+type WebhookBasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordFile is read into Password.
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// Authenticate reports whether the request carries the bearer token or the
+// basic auth credentials of the webhook.
+func (wc *WebhookConfig) Authenticate(r *http.Request) bool {
+	if wc.BearerToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(wc.BearerToken), []byte(token)) == 1 {
+			return true
+		}
+	}
+	if wc.BasicAuth != nil {
+		user, password, ok := r.BasicAuth()
+		// Compare both to not leak which one was wrong.
+		userOK := subtle.ConstantTimeCompare([]byte(wc.BasicAuth.Username), []byte(user))
+		passwordOK := subtle.ConstantTimeCompare([]byte(wc.BasicAuth.Password), []byte(password))
+		if ok && userOK&passwordOK == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookAlert declares the recorder actions for an alert.
+type WebhookAlert struct {
+	Alertname string `yaml:"alertname"`
+	// Firing and Resolved are the recorder actions, "start" or "stop",
+	// taken when the alert fires and resolves. Empty means no action.
+	Firing   string `yaml:"firing,omitempty"`
+	Resolved string `yaml:"resolved,omitempty"`
+}
+
+// DefaultWebhookConfig is used for unset webhook settings.
+var DefaultWebhookConfig = WebhookConfig{
+	TargetLabel:   "instance",
+	RecorderLabel: "recorder",
+}
+
+// Alert returns the actions for the named alert, or nil when the alert is
+// not acted upon.
+func (wc *WebhookConfig) Alert(alertname string) *WebhookAlert {
+	for i := range wc.Alerts {
+		if wc.Alerts[i].Alertname == alertname {
+			return &wc.Alerts[i]
+		}
+	}
+	return nil
+}
+
+// Control actions that can be granted to roles.
+const (
+	ActionRecorderStart  = "recorder_start"
+	ActionRecorderStop   = "recorder_stop"
+	ActionPublisherStart = "publisher_start"
+	ActionPublisherStop  = "publisher_stop"
+	ActionReboot         = "reboot"
+	// ActionMaintenance allows adding and removing maintenance windows for
+	// the targets through /api/maintenance.
+	ActionMaintenance = "maintenance"
+)
+
+// ControlActions lists every control action performed on a device.
+var ControlActions = []string{ActionRecorderStart, ActionRecorderStop, ActionPublisherStart, ActionPublisherStop, ActionReboot}
+
+// RoleActions lists every action that can be granted to roles.
+var RoleActions = append(ControlActions[:len(ControlActions):len(ControlActions)], ActionMaintenance)
+
+// ControlConfig configures the device control API, which lets operators act
+// on devices with the credentials of the exporter.
+type ControlConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// AuditLog is the file every control request is appended to.
+	AuditLog string `yaml:"audit_log,omitempty"`
+	// TargetGroups names lists of targets or shell-style patterns, matched
+	// like the targets of maintenance windows.
+	TargetGroups map[string][]string    `yaml:"target_groups,omitempty"`
+	Roles        map[string]ControlRole `yaml:"roles,omitempty"`
+	Tokens       []ControlToken         `yaml:"tokens,omitempty"`
+}
+
+// ControlRole grants actions on the targets of some target groups.
+type ControlRole struct {
+	Actions      []string `yaml:"actions"`
+	TargetGroups []string `yaml:"target_groups"`
+}
+
+// ControlToken is a bearer token and the roles granted to its holder.
+type ControlToken struct {
+	// Name identifies the token in the audit log.
+	Name      string   `yaml:"name"`
+	Token     string   `yaml:"token,omitempty"`
+	TokenFile string   `yaml:"token_file,omitempty"`
+	Roles     []string `yaml:"roles"`
+}
+
+// Authenticate returns the token matching the given bearer token, or nil.
+func (cc *ControlConfig) Authenticate(token string) *ControlToken {
+	if token == "" {
+		return nil
+	}
+	var match *ControlToken
+	for i := range cc.Tokens {
+		// Compare every token to not leak which one matched.
+		if subtle.ConstantTimeCompare([]byte(cc.Tokens[i].Token), []byte(token)) == 1 {
+			match = &cc.Tokens[i]
+		}
+	}
+	return match
+}
+
+// Authorized reports whether the roles of the token grant the action on the
+// target.
+func (cc *ControlConfig) Authorized(t *ControlToken, action string, target string) bool {
+	for _, roleName := range t.Roles {
+		role := cc.Roles[roleName]
+		if !containsString(role.Actions, action) {
+			continue
+		}
+		for _, group := range role.TargetGroups {
+			if matchTarget(cc.TargetGroups[group], target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Grants reports whether any role of the token grants the action, on
+// whichever targets.
+func (cc *ControlConfig) Grants(t *ControlToken, action string) bool {
+	for _, roleName := range t.Roles {
+		if containsString(cc.Roles[roleName].Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceAuthorized reports whether the roles of the token grant
+// maintenance on every target the window applies to. The target patterns of
+// the window must be matched by the target groups as written, e.g. a window
+// for "*" needs a target group holding "*". A window with only a selector
+// applies to the configured targets with matching labels.
+func (c *Config) MaintenanceAuthorized(t *ControlToken, w *MaintenanceWindow) bool {
+	if !c.Control.Grants(t, ActionMaintenance) {
+		return false
+	}
+	if len(w.Targets) > 0 {
+		for _, pattern := range w.Targets {
+			if !c.Control.Authorized(t, ActionMaintenance, pattern) {
+				return false
+			}
+		}
+		return true
+	}
+	for target, tc := range c.Targets {
+		if w.Matches(target, &tc) && !c.Control.Authorized(t, ActionMaintenance, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// MaintenanceWindow declares a period in which devices are being serviced.
+// A window either runs once from Start to End, or recurs, optionally only
+// between Start and End.
+type MaintenanceWindow struct {
+	ID      string `yaml:"id,omitempty" json:"id"`
+	Comment string `yaml:"comment,omitempty" json:"comment,omitempty"`
+	// Targets are probe targets or shell-style patterns matched against the
+	// target and its host name.
+	Targets []string `yaml:"targets,omitempty" json:"targets,omitempty"`
+	// Selector matches the labels of configured targets.
+	Selector  map[string]string    `yaml:"selector,omitempty" json:"selector,omitempty"`
+	Start     time.Time            `yaml:"start,omitempty" json:"start,omitempty"`
+	End       time.Time            `yaml:"end,omitempty" json:"end,omitempty"`
+	Recurring *MaintenanceSchedule `yaml:"recurring,omitempty" json:"recurring,omitempty"`
+}
+
+// MaintenanceSchedule makes a maintenance window recur every day, or on the
+// given weekdays, at the same time.
+type MaintenanceSchedule struct {
+	// Weekdays are abbreviated English day names such as "sat".
+	Weekdays []string `yaml:"weekdays,omitempty" json:"weekdays,omitempty"`
+	// Time is the local start time as HH:MM.
+	Time     string         `yaml:"time" json:"time"`
+	Duration model.Duration `yaml:"duration" json:"duration"`
+	// Timezone is an IANA time zone name, the local time zone if empty.
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+var maintenanceWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks that the window is complete.
+func (w *MaintenanceWindow) Validate() error {
+	if len(w.Targets) == 0 && len(w.Selector) == 0 {
+		return fmt.Errorf("maintenance window %q needs targets or a selector", w.ID)
+	}
+	for _, pattern := range w.Targets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid target %q in maintenance window %q: %s", pattern, w.ID, err)
+		}
+	}
+	for name, value := range w.Selector {
+		if value == "" {
+			return fmt.Errorf("empty value for label %q in the selector of maintenance window %q", name, w.ID)
+		}
+	}
+	if !w.Start.IsZero() && !w.End.IsZero() && !w.End.After(w.Start) {
+		return fmt.Errorf("maintenance window %q ends before it starts", w.ID)
+	}
+	if w.Recurring == nil {
+		if w.Start.IsZero() || w.End.IsZero() {
+			return fmt.Errorf("maintenance window %q needs start and end, or recurring", w.ID)
+		}
+		return nil
+	}
+	r := w.Recurring
+	if _, err := time.Parse("15:04", r.Time); err != nil {
+		return fmt.Errorf("invalid time %q in maintenance window %q, expected HH:MM", r.Time, w.ID)
+	}
+	if r.Duration <= 0 || time.Duration(r.Duration) > 7*24*time.Hour {
+		return fmt.Errorf("duration of maintenance window %q must be positive and at most a week", w.ID)
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q in maintenance window %q", r.Timezone, w.ID)
+	}
+	for _, day := range r.Weekdays {
+		if _, ok := maintenanceWeekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid weekday %q in maintenance window %q", day, w.ID)
+		}
+	}
+	return nil
+}
+
+// Active reports whether the window is in effect at the given time.
+func (w *MaintenanceWindow) Active(now time.Time) bool {
+	if !w.Start.IsZero() && now.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && !now.Before(w.End) {
+		return false
+	}
+	if w.Recurring == nil {
+		return true
+	}
+	r := w.Recurring
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return false
+	}
+	start, err := time.Parse("15:04", r.Time)
+	if err != nil {
+		return false
+	}
+	local := now.In(loc)
+	// A recurrence may have begun on one of the previous days.
+	for i := 0; i <= 7; i++ {
+		day := local.AddDate(0, 0, -i)
+		if !r.on(day.Weekday()) {
+			continue
+		}
+		begin := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		if !now.Before(begin) && now.Before(begin.Add(time.Duration(r.Duration))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the window will not be in effect again.
+func (w *MaintenanceWindow) Expired(now time.Time) bool {
+	return !w.End.IsZero() && !now.Before(w.End)
+}
+
+func (r *MaintenanceSchedule) on(weekday time.Weekday) bool {
+	if len(r.Weekdays) == 0 {
+		return true
+	}
+	for _, day := range r.Weekdays {
+		if maintenanceWeekdays[strings.ToLower(day)] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the window applies to the target. tc holds the
+// settings of the target, nil if it is not configured. A selector only
+// matches configured targets.
+func (w *MaintenanceWindow) Matches(target string, tc *TargetConfig) bool {
+	if len(w.Targets) > 0 && !matchTarget(w.Targets, target) {
+		return false
+	}
+	if len(w.Selector) == 0 {
+		return true
+	}
+	if tc == nil {
+		return false
+	}
+	for name, value := range w.Selector {
+		if v, ok := tc.Labels[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTarget reports whether the target equals one of the patterns or
+// matches it as a shell-style pattern, either as a whole or by host name.
+func matchTarget(patterns []string, target string) bool {
+	host, _ := TargetHost(target)
+	for _, pattern := range patterns {
+		if pattern == target {
+			return true
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok && host != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelProfile declares the expected encoding settings of a channel.
+// Fields left empty are not checked.
+type ChannelProfile struct {
+	Codec      string  `yaml:"codec,omitempty"`
+	Resolution string  `yaml:"resolution,omitempty"`
+	Framerate  float64 `yaml:"framerate,omitempty"`
+	// Bitrate is the target bitrate in kbit/s.
+	Bitrate int64  `yaml:"bitrate,omitempty"`
+	Layout  string `yaml:"layout,omitempty"`
+}
+
+// Drift compares the actual channel settings with the profile and returns
+// for every checked field whether it differs.
+func (p *ChannelProfile) Drift(codec string, resolution string, framerate float64, bitrate int64, layout string) map[string]bool {
+	drift := map[string]bool{}
+	if p.Codec != "" {
+		drift["codec"] = !strings.EqualFold(p.Codec, codec)
+	}
+	if p.Resolution != "" {
+		drift["resolution"] = !strings.EqualFold(p.Resolution, resolution)
+	}
+	if p.Framerate != 0 {
+		drift["framerate"] = p.Framerate != framerate
+	}
+	if p.Bitrate != 0 {
+		drift["bitrate"] = p.Bitrate != bitrate
+	}
+	if p.Layout != "" {
+		drift["layout"] = p.Layout != layout
+	}
+	return drift
+}
+
+// TargetConfig holds the settings for a single device.
+type TargetConfig struct {
+	Username     string                `yaml:"username,omitempty"`
+	Password     string                `yaml:"password,omitempty"`
+	PasswordFile string                `yaml:"password_file,omitempty"`
+	TLSConfig    *promconfig.TLSConfig `yaml:"tls_config,omitempty"`
+
+	// Labels describe the device, e.g. its building, for selecting it in
+	// maintenance windows.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// FirmwareUpdateCheck can be set to false for devices that cannot reach
+	// the vendor update server, e.g. on air-gapped networks.
+	FirmwareUpdateCheck *bool `yaml:"firmware_update_check,omitempty"`
+
+	// SilenceThresholdDBFS overrides the audio silence threshold.
+	SilenceThresholdDBFS *float64 `yaml:"silence_threshold_dbfs,omitempty"`
+
+	// ChannelPreview enables or disables the channel preview collector for
+	// this device regardless of the global setting.
+	ChannelPreview *bool `yaml:"channel_preview,omitempty"`
+
+	// Thermal overrides the thermal thresholds that are set.
+	Thermal *ThermalConfig `yaml:"thermal,omitempty"`
+
+	// Schedule is the path of an iCalendar file holding the recordings
+	// expected from this device. It is read again whenever it changes.
+	Schedule string `yaml:"schedule,omitempty"`
+	// ScheduleRecorders lists the ids of the recorders that record the
+	// schedule. Any recorder counts when empty.
+	ScheduleRecorders []string `yaml:"schedule_recorders,omitempty"`
+
+	// ChannelProfile names the profile every channel is expected to match.
+	ChannelProfile string `yaml:"channel_profile,omitempty"`
+	// ChannelProfiles overrides the profile for individual channel ids.
+	ChannelProfiles map[string]string `yaml:"channel_profiles,omitempty"`
+
+	tls *tls.Config
+}
+
+// DefaultSilenceThresholdDBFS is used when no silence threshold is configured.
+const DefaultSilenceThresholdDBFS = -60.0
+
+// DefaultPreviewConfig is used for unset preview settings.
+var DefaultPreviewConfig = PreviewConfig{
+	BlackLuminance:   0.05,
+	FrozenDifference: 0.01,
+}
+
+// DefaultThermalConfig is used when the thermal section is missing.
+var DefaultThermalConfig = ThermalConfig{
+	CPUTemperatureCelsius:    80,
+	SensorTemperatureCelsius: 60,
+}
+
+// DefaultScheduleConfig is used for unset schedule settings.
+var DefaultScheduleConfig = ScheduleConfig{
+	GracePeriod: model.Duration(5 * time.Minute),
+}
+
+// DefaultFirmwareConfig is used for unset firmware settings.
+var DefaultFirmwareConfig = FirmwareConfig{
+	UpdateCheckInterval: model.Duration(24 * time.Hour),
+}
+
+// SafeConfig guards a Config that may be replaced on reload.
+type SafeConfig struct {
+	sync.RWMutex
+	C *Config
+}
+
+// ReloadConfig reads and validates the config file and swaps it in on success.
+// An empty file name loads the default (empty) configuration.
+func (sc *SafeConfig) ReloadConfig(confFile string, logger log.Logger) (err error) {
+	defer func() {
+		if err != nil {
+			configReloadSuccess.Set(0)
+		} else {
+			configReloadSuccess.Set(1)
+			configReloadSeconds.SetToCurrentTime()
+		}
+	}()
+
+	c, err := LoadFile(confFile)
+	if err != nil {
+		return err
+	}
+
+	sc.Lock()
+	sc.C = c
+	sc.Unlock()
+
+	if len(c.AllowedTargets) == 0 {
+		level.Warn(logger).Log("msg", "No allowed_targets configured, every target may be probed")
+	}
+	return nil
+}
+
+// Get returns the currently active configuration.
+func (sc *SafeConfig) Get() *Config {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.C
+}
+
+// TargetAllowed reports whether the given probe target matches the
+// allowlist. Host names are not resolved: a host name matching no host entry
+// is allowed when network entries are configured, and the addresses it
+// resolves to are checked by AddressAllowed when connecting, so the check
+// applies to the address actually connected to.
+func (c *Config) TargetAllowed(target string) (bool, error) {
+	if len(c.AllowedTargets) == 0 {
+		return true, nil
+	}
+	host, err := TargetHost(target)
+	if err != nil {
+		return false, err
+	}
+	if c.allowlist.allowed(host) {
+		return true, nil
+	}
+	return net.ParseIP(host) == nil && len(c.allowlist.networks) > 0, nil
+}
+
+// AddressAllowed reports whether a connection to the address a target host
+// resolved to is allowed: the host matches a host entry of the allowlist,
+// or the address lies in an allowed network.
+func (c *Config) AddressAllowed(host string, ip net.IP) bool {
+	if len(c.AllowedTargets) == 0 {
+		return true
+	}
+	if net.ParseIP(host) == nil && c.allowlist.allowed(strings.ToLower(host)) {
+		return true
+	}
+	return c.allowlist.containsIP(ip)
+}
+
+// WebhookTargetAllowed reports whether the webhook may act on the target,
+// which must be allowed by both the webhook and the probe allowlist. As
+// connections are only checked against the probe allowlist, network entries
+// of the webhook allowlist only match targets given as addresses.
+func (c *Config) WebhookTargetAllowed(target string) (bool, error) {
+	if len(c.Webhook.AllowedTargets) == 0 {
+		return false, nil
+	}
+	host, err := TargetHost(target)
+	if err != nil {
+		return false, err
+	}
+	if !c.Webhook.allowlist.allowed(host) {
+		return false, nil
+	}
+	return c.TargetAllowed(target)
+}
+
+// Credentials returns the device credentials configured for the target.
+func (c *Config) Credentials(target string) (string, string) {
+	if tc := c.Target(target); tc.Username != "" {
+		return tc.Username, tc.Password
+	}
+	return c.Username, c.Password
+}
+
+// TLS returns the TLS settings for connections to the target, or nil for the
+// default.
+func (c *Config) TLS(target string) *tls.Config {
+	if tc := c.Target(target); tc.tls != nil {
+		return tc.tls
+	}
+	return c.tls
+}
+
+// ProbeCredentials returns the credentials for probe requests of the target
+// without credentials of their own. The credentials of the config file are
+// only sent to targets listed under targets or, when allowed_targets is set,
+// to the allowed targets, so a probe request cannot have them sent to an
+// arbitrary host. ok is false when they are withheld.
+func (c *Config) ProbeCredentials(target string) (user string, password string, ok bool) {
+	if _, listed := c.LookupTarget(target); !listed && len(c.AllowedTargets) == 0 {
+		return "", "", false
+	}
+	user, password = c.Credentials(target)
+	return user, password, true
+}
+
+// Target returns the settings for the given probe target. Targets are looked
+// up by their exact name first and by host name otherwise.
+func (c *Config) Target(target string) TargetConfig {
+	tc, _ := c.LookupTarget(target)
+	return tc
+}
+
+// LookupTarget returns the settings for the given probe target like Target.
+// ok is false when the target is not configured.
+func (c *Config) LookupTarget(target string) (TargetConfig, bool) {
+	if tc, ok := c.Targets[target]; ok {
+		return tc, true
+	}
+	host, err := TargetHost(target)
+	if err != nil {
+		return TargetConfig{}, false
+	}
+	for name, tc := range c.Targets {
+		if h, err := TargetHost(name); err == nil && h == host {
+			return tc, true
+		}
+	}
+	return TargetConfig{}, false
+}
+
+// FirmwareUpdateCheckEnabled reports whether firmware update checks may be
+// triggered on the device. Checks are enabled unless disabled explicitly.
+func (tc TargetConfig) FirmwareUpdateCheckEnabled() bool {
+	return tc.FirmwareUpdateCheck == nil || *tc.FirmwareUpdateCheck
+}
+
+// SilenceThreshold returns the audio silence threshold in dBFS for the target.
+func (c *Config) SilenceThreshold(target string) float64 {
+	if tc := c.Target(target); tc.SilenceThresholdDBFS != nil {
+		return *tc.SilenceThresholdDBFS
+	}
+	if c.Audio.SilenceThresholdDBFS != nil {
+		return *c.Audio.SilenceThresholdDBFS
+	}
+	return DefaultSilenceThresholdDBFS
+}
+
+// PreviewEnabled reports whether channel previews are analyzed for the target.
+func (c *Config) PreviewEnabled(target string) bool {
+	if tc := c.Target(target); tc.ChannelPreview != nil {
+		return *tc.ChannelPreview
+	}
+	return c.Preview.Enabled
+}
+
+// ThermalThresholds returns the thermal thresholds for the target.
+func (c *Config) ThermalThresholds(target string) ThermalConfig {
+	thresholds := c.Thermal
+	if override := c.Target(target).Thermal; override != nil {
+		if override.CPUTemperatureCelsius != 0 {
+			thresholds.CPUTemperatureCelsius = override.CPUTemperatureCelsius
+		}
+		if override.SensorTemperatureCelsius != 0 {
+			thresholds.SensorTemperatureCelsius = override.SensorTemperatureCelsius
+		}
+		if override.FanMinimumRPM != 0 {
+			thresholds.FanMinimumRPM = override.FanMinimumRPM
+		}
+	}
+	return thresholds
+}
+
+// ChannelProfile returns the expected profile for a channel of the target, or
+// nil when the channel is not checked.
+func (c *Config) ChannelProfile(target string, channel string) *ChannelProfile {
+	tc := c.Target(target)
+	name := tc.ChannelProfile
+	if n, ok := tc.ChannelProfiles[channel]; ok {
+		name = n
+	}
+	profile, ok := c.ChannelProfiles[name]
+	if !ok {
+		return nil
+	}
+	return &profile
+}
+
+// FirmwarePolicy returns the approved firmware policy for the given device
+// model, or nil when no policy applies.
+func (c *Config) FirmwarePolicy(model string) *FirmwarePolicy {
+	model = strings.ToLower(model)
+	for i, policy := range c.Firmware.Approved {
+		if policy.Model == "" {
+			return &c.Firmware.Approved[i]
+		}
+		if ok, _ := path.Match(strings.ToLower(policy.Model), model); ok {
+			return &c.Firmware.Approved[i]
+		}
+	}
+	return nil
+}
+
+// TargetHost extracts the hostname from a probe target, which may be given
+// with or without a scheme.
+func TargetHost(target string) (string, error) {
+	if !strings.Contains(target, "://") {
+		target = "//" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("target %q has no host", target)
+	}
+	return strings.ToLower(u.Hostname()), nil
+}
+
+type allowlist struct {
+	hosts    []string
+	networks []*net.IPNet
+}
+
+func newAllowlist(entries []string) (allowlist, error) {
+	al := allowlist{}
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			al.networks = append(al.networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			al.networks = append(al.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		pattern := strings.ToLower(entry)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return al, fmt.Errorf("invalid allowed_targets entry %q: %s", entry, err)
+		}
+		al.hosts = append(al.hosts, pattern)
+	}
+	return al, nil
+}
+
+// allowed reports whether the host matches a host entry, or is an address
+// in an allowed network. Host names are not resolved.
+func (al allowlist) allowed(host string) bool {
+	for _, pattern := range al.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return al.containsIP(ip)
+	}
+	return false
+}
+
+func (al allowlist) containsIP(ip net.IP) bool {
+	for _, network := range al.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
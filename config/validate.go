@@ -0,0 +1,577 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	promconfig "github.com/prometheus/common/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem found in a config file.
+type Error struct {
+	// Line is the line the problem was found on, 0 if unknown.
+	Line int
+	Msg  string
+}
+
+func (e Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// Errors holds every problem found in a config file.
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadFile parses the given config file. An empty file name returns an
+// empty configuration. A config file that cannot be read or parsed results
+// in an error, otherwise every problem in it is returned as Errors.
+func LoadFile(confFile string) (*Config, error) {
+	if confFile == "" {
+		c := &Config{}
+		if err := c.validate(nil); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	yamlFile, err := os.ReadFile(confFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+	return Load(yamlFile)
+}
+
+// Load parses a config. Unknown fields are errors.
+func Load(yamlFile []byte) (*Config, error) {
+	var errs Errors
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &root); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", err)
+	}
+
+	// The decoder stops at the first value that fails to decode itself, such
+	// as a negative duration, without its line. Those values are checked
+	// first and left out, so every other problem is still found.
+	var invalid []*yaml.Node
+	errs = append(errs, checkValues(&root, reflect.TypeOf(Config{}), "", &invalid)...)
+	yamlFile = blankValues(yamlFile, invalid)
+
+	c := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		switch {
+		case errors.As(err, &typeErr):
+			// The decoder keeps going after type errors and reports them all.
+			for _, msg := range typeErr.Errors {
+				errs = append(errs, parseYAMLError(msg))
+			}
+		case len(invalid) == 0:
+			errs = append(errs, parseYAMLError(err.Error()))
+		}
+	}
+
+	if err := c.validate(&root); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return nil, errs
+	}
+	return c, nil
+}
+
+// parseYAMLError extracts the line number from a yaml.v3 error message such
+// as "line 3: field foo not found in type config.Config".
+func parseYAMLError(msg string) Error {
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+		return Error{Line: line, Msg: strings.TrimSpace(msg[strings.Index(msg, ":")+1:])}
+	}
+	return Error{Msg: msg}
+}
+
+var (
+	yamlUnmarshalerType   = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	legacyUnmarshalerType = reflect.TypeOf((*interface {
+		UnmarshalYAML(func(interface{}) error) error
+	})(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// checkValues decodes the values of the node that are destined for types
+// decoding themselves, e.g. model.Duration and time.Time, and returns an
+// error with the line of every value that fails. The failing nodes are added
+// to invalid. key is the mapping key the node is the value of.
+func checkValues(node *yaml.Node, t reflect.Type, key string, invalid *[]*yaml.Node) Errors {
+	if node.Kind == yaml.DocumentNode {
+		var errs Errors
+		for _, n := range node.Content {
+			errs = append(errs, checkValues(n, t, key, invalid)...)
+		}
+		return errs
+	}
+	if node.Kind == yaml.AliasNode || node.ShortTag() == "!!null" {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if pt := reflect.PtrTo(t); pt.Implements(yamlUnmarshalerType) || pt.Implements(legacyUnmarshalerType) || pt.Implements(textUnmarshalerType) {
+		err := node.Decode(reflect.New(t).Interface())
+		var typeErr *yaml.TypeError
+		if err == nil || errors.As(err, &typeErr) {
+			// Type errors are reported by the decoder.
+			return nil
+		}
+		*invalid = append(*invalid, node)
+		return Errors{{Line: node.Line, Msg: fmt.Sprintf("invalid %s: %s", key, err)}}
+	}
+
+	var errs Errors
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			fields[name] = f.Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if ft, ok := fields[node.Content[i].Value]; ok {
+				errs = append(errs, checkValues(node.Content[i+1], ft, node.Content[i].Value, invalid)...)
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, checkValues(node.Content[i+1], t.Elem(), node.Content[i].Value, invalid)...)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for _, n := range node.Content {
+			errs = append(errs, checkValues(n, t.Elem(), key, invalid)...)
+		}
+	}
+	return errs
+}
+
+// blankValues replaces the given scalar values in the YAML document by null,
+// keeping every other value on its line. Values that span lines are kept.
+func blankValues(yamlFile []byte, nodes []*yaml.Node) []byte {
+	if len(nodes) == 0 {
+		return yamlFile
+	}
+	lines := strings.Split(string(yamlFile), "\n")
+	// Replace from the right, so columns of values on the same line stay
+	// valid.
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Line != nodes[j].Line {
+			return nodes[i].Line < nodes[j].Line
+		}
+		return nodes[i].Column > nodes[j].Column
+	})
+	for _, node := range nodes {
+		if node.Kind != yaml.ScalarNode || node.Line < 1 || node.Line > len(lines) {
+			continue
+		}
+		line := []rune(lines[node.Line-1])
+		start := node.Column - 1
+		if start < 0 || start >= len(line) {
+			continue
+		}
+		end := scalarEnd(line, start, node)
+		if end < 0 {
+			continue
+		}
+		lines[node.Line-1] = string(line[:start]) + "~" + string(line[end:])
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// scalarEnd returns the end of the scalar node starting at start on the line,
+// or -1 if it does not end on the line.
+func scalarEnd(line []rune, start int, node *yaml.Node) int {
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	case 0, yaml.FlowStyle:
+		value := []rune(node.Value)
+		if start+len(value) <= len(line) && string(line[start:start+len(value)]) == node.Value {
+			return start + len(value)
+		}
+	}
+	return -1
+}
+
+// validator collects the problems of a config, locating them in the parsed
+// YAML document.
+type validator struct {
+	root *yaml.Node
+	errs Errors
+}
+
+// errorf records a problem with the value at the given path of mapping keys
+// and sequence indexes, e.g. "targets", "pearl.local", "channel_profile".
+func (v *validator) errorf(path []interface{}, format string, args ...interface{}) {
+	v.errs = append(v.errs, Error{Line: v.line(path), Msg: fmt.Sprintf(format, args...)})
+}
+
+// line returns the line of the value at the path, or of the closest parent
+// present in the document when the value is missing.
+func (v *validator) line(path []interface{}) int {
+	if v.root == nil {
+		return 0
+	}
+	node := v.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return line
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					line = node.Content[i].Line
+				}
+			}
+		case int:
+			if node.Kind != yaml.SequenceNode || key >= len(node.Content) {
+				return line
+			}
+			next = node.Content[key]
+			line = next.Line
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+	return line
+}
+
+func at(path ...interface{}) []interface{} {
+	return path
+}
+
+// validate sets defaults and checks the config, returning Errors if there
+// are any problems.
+func (c *Config) validate(root *yaml.Node) error {
+	v := &validator{root: root}
+
+	if c.PasswordFile != "" {
+		c.Password = v.readSecret(at("password_file"), c.PasswordFile)
+	}
+	c.tls = v.tlsConfig(at("tls_config"), c.TLSConfig)
+
+	if c.Firmware.UpdateCheckInterval == 0 {
+		c.Firmware.UpdateCheckInterval = DefaultFirmwareConfig.UpdateCheckInterval
+	}
+	if c.Firmware.UpdateCheckInterval < 0 {
+		v.errorf(at("firmware", "update_check_interval"), "firmware update_check_interval must be positive")
+	}
+	for i, policy := range c.Firmware.Approved {
+		if policy.MinimumVersion == "" && len(policy.Versions) == 0 {
+			v.errorf(at("firmware", "approved", i), "firmware approved policy %d for model %q needs minimum_version or versions", i, policy.Model)
+		}
+		if _, err := path.Match(strings.ToLower(policy.Model), ""); err != nil {
+			v.errorf(at("firmware", "approved", i, "model"), "invalid firmware approved model %q: %s", policy.Model, err)
+		}
+	}
+
+	if c.Audio.SilenceThresholdDBFS == nil {
+		threshold := DefaultSilenceThresholdDBFS
+		c.Audio.SilenceThresholdDBFS = &threshold
+	}
+	if *c.Audio.SilenceThresholdDBFS > 0 {
+		v.errorf(at("audio", "silence_threshold_dbfs"), "audio silence_threshold_dbfs must not be positive")
+	}
+
+	if c.Preview.BlackLuminance == 0 {
+		c.Preview.BlackLuminance = DefaultPreviewConfig.BlackLuminance
+	}
+	if c.Preview.FrozenDifference == 0 {
+		c.Preview.FrozenDifference = DefaultPreviewConfig.FrozenDifference
+	}
+	if c.Preview.BlackLuminance < 0 || c.Preview.BlackLuminance > 1 {
+		v.errorf(at("preview", "black_luminance"), "preview black_luminance must be between 0 and 1")
+	}
+	if c.Preview.FrozenDifference < 0 || c.Preview.FrozenDifference > 1 {
+		v.errorf(at("preview", "frozen_difference"), "preview frozen_difference must be between 0 and 1")
+	}
+
+	if c.Schedule.GracePeriod == 0 {
+		c.Schedule.GracePeriod = DefaultScheduleConfig.GracePeriod
+	}
+	if c.Schedule.GracePeriod < 0 {
+		v.errorf(at("schedule", "grace_period"), "schedule grace_period must be positive")
+	}
+
+	if c.Thermal == (ThermalConfig{}) {
+		c.Thermal = DefaultThermalConfig
+	}
+	v.thermal(at("thermal"), &c.Thermal, "")
+
+	for name, tc := range c.Targets {
+		if tc.PasswordFile != "" {
+			tc.Password = v.readSecret(at("targets", name, "password_file"), tc.PasswordFile)
+		}
+		tc.tls = v.tlsConfig(at("targets", name, "tls_config"), tc.TLSConfig)
+		if tc.SilenceThresholdDBFS != nil && *tc.SilenceThresholdDBFS > 0 {
+			v.errorf(at("targets", name, "silence_threshold_dbfs"), "silence_threshold_dbfs for target %q must not be positive", name)
+		}
+		if _, ok := c.ChannelProfiles[tc.ChannelProfile]; tc.ChannelProfile != "" && !ok {
+			v.errorf(at("targets", name, "channel_profile"), "unknown channel_profile %q for target %q", tc.ChannelProfile, name)
+		}
+		for channel, profile := range tc.ChannelProfiles {
+			if _, ok := c.ChannelProfiles[profile]; !ok {
+				v.errorf(at("targets", name, "channel_profiles", channel), "unknown channel profile %q for channel %q of target %q", profile, channel, name)
+			}
+		}
+		if tc.Thermal != nil {
+			v.thermal(at("targets", name, "thermal"), tc.Thermal, fmt.Sprintf(" for target %q", name))
+		}
+		c.Targets[name] = tc
+	}
+
+	if c.Webhook.TargetLabel == "" {
+		c.Webhook.TargetLabel = DefaultWebhookConfig.TargetLabel
+	}
+	if c.Webhook.RecorderLabel == "" {
+		c.Webhook.RecorderLabel = DefaultWebhookConfig.RecorderLabel
+	}
+	if c.Webhook.Enabled && len(c.Webhook.AllowedTargets) == 0 {
+		v.errorf(at("webhook"), "webhook allowed_targets must not be empty when the webhook is enabled")
+	}
+	if c.Webhook.Enabled {
+		v.webhookAuth(&c.Webhook)
+	}
+	for i, alert := range c.Webhook.Alerts {
+		for _, action := range []string{alert.Firing, alert.Resolved} {
+			if action != "" && action != "start" && action != "stop" {
+				v.errorf(at("webhook", "alerts", i), "invalid action %q for webhook alert %q, must be start or stop", action, alert.Alertname)
+			}
+		}
+	}
+	c.Webhook.allowlist = v.allowlist(at("webhook", "allowed_targets"), "webhook: ", c.Webhook.AllowedTargets)
+
+	v.control(&c.Control)
+
+	ids := map[string]bool{}
+	for i := range c.MaintenanceWindows {
+		w := &c.MaintenanceWindows[i]
+		if w.ID == "" {
+			w.ID = fmt.Sprintf("config-%d", i)
+		}
+		if ids[w.ID] {
+			v.errorf(at("maintenance_windows", i, "id"), "duplicate maintenance window id %q", w.ID)
+		}
+		ids[w.ID] = true
+		if err := w.Validate(); err != nil {
+			v.errorf(at("maintenance_windows", i), "%s", err)
+		}
+	}
+
+	c.allowlist = v.allowlist(at("allowed_targets"), "", c.AllowedTargets)
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// readSecret returns the trimmed content of a file holding a secret.
+func (v *validator) readSecret(path []interface{}, file string) string {
+	secret, err := ReadSecret(file)
+	if err != nil {
+		v.errorf(path, "%s", err)
+	}
+	return secret
+}
+
+// ReadSecret reads a password or token from a file, without surrounding
+// whitespace such as a trailing newline.
+func ReadSecret(file string) (string, error) {
+	secret, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %s", file, err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+func (v *validator) tlsConfig(path []interface{}, cfg *promconfig.TLSConfig) *tls.Config {
+	if cfg == nil {
+		return nil
+	}
+	tlsConfig, err := promconfig.NewTLSConfig(cfg)
+	if err != nil {
+		v.errorf(path, "invalid tls_config: %s", err)
+		return nil
+	}
+	return tlsConfig
+}
+
+func (v *validator) thermal(path []interface{}, t *ThermalConfig, suffix string) {
+	if t.CPUTemperatureCelsius < 0 || t.SensorTemperatureCelsius < 0 || t.FanMinimumRPM < 0 {
+		v.errorf(path, "thermal thresholds must not be negative%s", suffix)
+	}
+}
+
+func (v *validator) allowlist(path []interface{}, prefix string, entries []string) allowlist {
+	al, err := newAllowlist(entries)
+	if err != nil {
+		v.errorf(path, "%s%s", prefix, err)
+	}
+	return al
+}
+
+// webhookAuth reads the credentials of the enabled webhook.
+func (v *validator) webhookAuth(wc *WebhookConfig) {
+	if wc.AuditLog == "" {
+		v.errorf(at("webhook"), "webhook audit_log is required when the webhook is enabled")
+	}
+	if wc.BearerTokenFile != "" {
+		wc.BearerToken = v.readSecret(at("webhook", "bearer_token_file"), wc.BearerTokenFile)
+	}
+	if wc.BearerToken != "" && len(wc.BearerToken) < 16 {
+		v.errorf(at("webhook"), "webhook bearer_token must be at least 16 characters")
+	}
+	if ba := wc.BasicAuth; ba != nil {
+		if ba.PasswordFile != "" {
+			ba.Password = v.readSecret(at("webhook", "basic_auth", "password_file"), ba.PasswordFile)
+		}
+		if ba.Username == "" || ba.Password == "" {
+			v.errorf(at("webhook", "basic_auth"), "webhook basic_auth needs a username and password")
+		}
+	}
+	if wc.BearerToken == "" && wc.BasicAuth == nil {
+		v.errorf(at("webhook"), "webhook bearer_token, bearer_token_file or basic_auth is required when the webhook is enabled")
+	}
+}
+
+func (v *validator) control(cc *ControlConfig) {
+	if !cc.Enabled {
+		return
+	}
+	if cc.AuditLog == "" {
+		v.errorf(at("control"), "control audit_log is required when the control API is enabled")
+	}
+	for name, patterns := range cc.TargetGroups {
+		for i, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.errorf(at("control", "target_groups", name, i), "invalid target %q in control target group %q: %s", pattern, name, err)
+			}
+		}
+	}
+	for name, role := range cc.Roles {
+		for i, action := range role.Actions {
+			if !containsString(RoleActions, action) {
+				v.errorf(at("control", "roles", name, "actions", i), "unknown action %q in control role %q", action, name)
+			}
+		}
+		for i, group := range role.TargetGroups {
+			if _, ok := cc.TargetGroups[group]; !ok {
+				v.errorf(at("control", "roles", name, "target_groups", i), "unknown target group %q in control role %q", group, name)
+			}
+		}
+	}
+	names := map[string]bool{}
+	for i := range cc.Tokens {
+		t := &cc.Tokens[i]
+		if t.Name == "" || names[t.Name] {
+			v.errorf(at("control", "tokens", i), "control tokens need a unique name")
+		}
+		names[t.Name] = true
+		if t.TokenFile != "" {
+			t.Token = v.readSecret(at("control", "tokens", i, "token_file"), t.TokenFile)
+		}
+		if len(t.Token) < 16 {
+			v.errorf(at("control", "tokens", i), "control token %q must be at least 16 characters", t.Name)
+		}
+		for j, role := range t.Roles {
+			if _, ok := cc.Roles[role]; !ok {
+				v.errorf(at("control", "tokens", i, "roles", j), "unknown role %q for control token %q", role, t.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLoadReportsEveryInvalidValue(t *testing.T) {
+	yamlFile := []byte(`firmware:
+  update_check_interval: -5m
+schedule:
+  grace_period: "soon"
+unknown_field: 1
+maintenance_windows:
+  - id: renovation
+    targets: [pearl.local]
+    start: 2022-07-01T00:00:00Z
+    end: 'tomorrow'
+  - id: north
+    selector: {building: ""}
+    start: 2022-07-01T00:00:00Z
+    end: 2022-07-02T00:00:00Z
+audio:
+  silence_threshold_dbfs: loud
+`)
+	_, err := Load(yamlFile)
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Load() = %v, want Errors", err)
+	}
+	want := map[int]bool{2: false, 4: false, 5: false, 10: false, 11: false, 16: false}
+	for _, e := range errs {
+		if _, ok := want[e.Line]; ok {
+			want[e.Line] = true
+		}
+	}
+	for line, found := range want {
+		if !found {
+			t.Errorf("no error on line %d in:\n%s", line, errs)
+		}
+	}
+}
+
+func TestLoadValidValues(t *testing.T) {
+	c, err := Load([]byte(`firmware:
+  update_check_interval: 12h
+maintenance_windows:
+  - id: weekly
+    targets: [pearl.local]
+    recurring: {weekdays: [sun], time: "03:00", duration: 1h}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Firmware.UpdateCheckInterval.String(); got != "12h" {
+		t.Errorf("update_check_interval = %s, want 12h", got)
+	}
+	if got := c.MaintenanceWindows[0].Recurring.Duration.String(); got != "1h" {
+		t.Errorf("maintenance window duration = %s, want 1h", got)
+	}
+}
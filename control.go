@@ -0,0 +1,293 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/prober"
+)
+
+var controlActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "pearl_exporter",
+	Name:      "control_actions_total",
+	Help:      "Number of requests to the control API, by action and result",
+}, []string{"action", "result"})
+
+func init() {
+	prometheus.MustRegister(controlActions)
+}
+
+// controlRequest is the body of a control API request. Recorder actions need
+// the recorder, publisher actions the channel and publisher.
+type controlRequest struct {
+	Target    string `json:"target"`
+	Action    string `json:"action"`
+	Recorder  string `json:"recorder,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	Publisher string `json:"publisher,omitempty"`
+}
+
+func (cr *controlRequest) validate() error {
+	switch {
+	case cr.Target == "":
+		return fmt.Errorf("target is required")
+	case strings.HasPrefix(cr.Action, "recorder_") && cr.Recorder == "":
+		return fmt.Errorf("recorder is required")
+	case strings.HasPrefix(cr.Action, "publisher_") && (cr.Channel == "" || cr.Publisher == ""):
+		return fmt.Errorf("channel and publisher are required")
+	}
+	return nil
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Token      string    `json:"token,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	controlRequest
+	// Window is the maintenance window added or removed.
+	Window *config.MaintenanceWindow `json:"maintenance_window,omitempty"`
+	// Alertname and Fingerprint identify the alert of a webhook action.
+	Alertname   string `json:"alertname,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+	// Suppressed is the number of failed authentications of the remote
+	// address left out of the audit log since its previous entry.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// auditLog appends JSON lines to the audit log file. The file is opened for
+// every entry so it can be rotated and reconfigured at any time.
+type auditLog struct {
+	mtx sync.Mutex
+}
+
+var controlAudit = &auditLog{}
+
+func (a *auditLog) write(file string, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maxControlRequestBytes limits the body of control and maintenance requests.
+const maxControlRequestBytes = 64 << 10
+
+// authFailures collapses the audit entries of failed authentications, so
+// unauthenticated requests cannot flood the audit log. Only the first failure
+// of a remote address per interval is audited, the number of failures left
+// out is reported with the next audited one.
+type authFailures struct {
+	interval time.Duration
+
+	mtx  sync.Mutex
+	last map[string]*authFailure
+}
+
+type authFailure struct {
+	time       time.Time
+	suppressed int
+}
+
+// maxAuthFailureAddrs limits the number of remote addresses tracked. Failures
+// of further addresses are counted together.
+const maxAuthFailureAddrs = 1024
+
+var (
+	controlAuthFailures = newAuthFailures(time.Minute)
+	webhookAuthFailures = newAuthFailures(time.Minute)
+)
+
+func newAuthFailures(interval time.Duration) *authFailures {
+	return &authFailures{interval: interval, last: map[string]*authFailure{}}
+}
+
+// record counts a failed authentication of the remote address. It returns
+// whether the failure is to be audited, and the number of failures of the
+// address left out since the last audited one.
+func (f *authFailures) record(addr string, now time.Time) (bool, int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if _, ok := f.last[addr]; !ok && len(f.last) >= maxAuthFailureAddrs {
+		for a, last := range f.last {
+			if now.Sub(last.time) >= f.interval {
+				delete(f.last, a)
+			}
+		}
+		if len(f.last) >= maxAuthFailureAddrs {
+			addr = "other"
+		}
+	}
+	last, ok := f.last[addr]
+	if ok && now.Sub(last.time) < f.interval {
+		last.suppressed++
+		return false, 0
+	}
+	suppressed := 0
+	if ok {
+		suppressed = last.suppressed
+	}
+	f.last[addr] = &authFailure{time: now}
+	return true, suppressed
+}
+
+// controlHandler performs a device action for an operator authenticated by
+// a bearer token whose roles grant the action on the target. Every request,
+// allowed or not, is written to the audit log and no request is performed
+// unless it could be audited. Failed authentications are collapsed in the
+// audit log by authFailures.
+func controlHandler(w http.ResponseWriter, r *http.Request, c *config.Config, logger log.Logger) {
+	if !c.Control.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "This endpoint requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entry := auditEntry{Time: time.Now(), RemoteAddr: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.RemoteAddr = host
+	}
+	audited := true
+	respond := func(status int, result string, err error) {
+		entry.Time = time.Now()
+		entry.Result = result
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		// Keep arbitrary strings out of the metric labels.
+		action := entry.Action
+		if !knownControlAction(action) {
+			action = "unknown"
+		}
+		controlActions.WithLabelValues(action, result).Inc()
+		if audited {
+			if auditErr := controlAudit.write(c.Control.AuditLog, entry); auditErr != nil {
+				level.Error(logger).Log("msg", "Unable to write audit log", "file", c.Control.AuditLog, "err", auditErr)
+			}
+			level.Info(logger).Log("msg", "Control request", "audit", "control", "token", entry.Token, "remote_addr", entry.RemoteAddr, "action", entry.Action, "target", entry.Target, "result", result, "err", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		body := map[string]string{"status": result}
+		if err != nil {
+			body["message"] = err.Error()
+		}
+		json.NewEncoder(w).Encode(body)
+	}
+
+	token := c.Control.Authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == nil {
+		audited, entry.Suppressed = controlAuthFailures.record(entry.RemoteAddr, time.Now())
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respond(http.StatusUnauthorized, "unauthenticated", fmt.Errorf("missing or unknown bearer token"))
+		return
+	}
+	entry.Token = token.Name
+	r.Body = http.MaxBytesReader(w, r.Body, maxControlRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&entry.controlRequest); err != nil {
+		entry.controlRequest = controlRequest{}
+		respond(http.StatusBadRequest, "invalid", fmt.Errorf("invalid request: %s", err))
+		return
+	}
+	if !knownControlAction(entry.Action) {
+		respond(http.StatusBadRequest, "invalid", fmt.Errorf("unknown action %q", entry.Action))
+		return
+	}
+	if err := entry.controlRequest.validate(); err != nil {
+		respond(http.StatusBadRequest, "invalid", err)
+		return
+	}
+
+	if !c.Control.Authorized(token, entry.Action, entry.Target) {
+		respond(http.StatusForbidden, "forbidden", fmt.Errorf("token %q may not %s on %q", token.Name, entry.Action, entry.Target))
+		return
+	}
+	if allowed, err := c.TargetAllowed(entry.Target); err != nil || !allowed {
+		respond(http.StatusForbidden, "forbidden", fmt.Errorf("target %q is not allowed", entry.Target))
+		return
+	}
+
+	// Refuse to act if the action cannot be audited.
+	entry.Result = "started"
+	if err := controlAudit.write(c.Control.AuditLog, entry); err != nil {
+		level.Error(logger).Log("msg", "Unable to write audit log, refusing control request", "file", c.Control.AuditLog, "err", err)
+		controlActions.WithLabelValues(entry.Action, "failed").Inc()
+		http.Error(w, "Unable to write audit log", http.StatusInternalServerError)
+		return
+	}
+
+	user, password := c.Credentials(entry.Target)
+	var err error
+	switch entry.Action {
+	case config.ActionRecorderStart, config.ActionRecorderStop:
+		err = prober.ControlRecorder(entry.Target, user, password, entry.Recorder, strings.TrimPrefix(entry.Action, "recorder_"))
+	case config.ActionPublisherStart, config.ActionPublisherStop:
+		err = prober.ControlPublisher(entry.Target, user, password, entry.Channel, entry.Publisher, strings.TrimPrefix(entry.Action, "publisher_"))
+	case config.ActionReboot:
+		err = prober.Reboot(entry.Target, user, password)
+	}
+	if err != nil {
+		respond(http.StatusBadGateway, "failed", err)
+		return
+	}
+	respond(http.StatusOK, "success", nil)
+}
+
+func knownControlAction(action string) bool {
+	for _, a := range config.ControlActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/mm-dict/pearl-exporter/config"
+)
+
+func TestAuthFailures(t *testing.T) {
+	f := newAuthFailures(time.Minute)
+	now := time.Date(2022, 10, 17, 9, 0, 0, 0, time.UTC)
+	steps := []struct {
+		addr       string
+		after      time.Duration
+		audited    bool
+		suppressed int
+	}{
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", time.Second, false, 0},
+		{"192.0.2.1", 2 * time.Second, false, 0},
+		{"192.0.2.2", 2 * time.Second, true, 0},
+		{"192.0.2.1", time.Minute, true, 2},
+		{"192.0.2.1", 90 * time.Second, false, 0},
+	}
+	for i, step := range steps {
+		audited, suppressed := f.record(step.addr, now.Add(step.after))
+		if audited != step.audited || suppressed != step.suppressed {
+			t.Errorf("step %d: record(%s) = %t, %d, want %t, %d", i, step.addr, audited, suppressed, step.audited, step.suppressed)
+		}
+	}
+
+	// Failures of addresses beyond the limit are counted together.
+	f = newAuthFailures(time.Minute)
+	for i := 0; i < maxAuthFailureAddrs; i++ {
+		f.record(strings.Repeat("x", i+1), now)
+	}
+	if audited, _ := f.record("192.0.2.1", now); !audited {
+		t.Error("the first failure beyond the limit was not audited")
+	}
+	if audited, _ := f.record("192.0.2.2", now); audited {
+		t.Error("a failure beyond the limit was audited again")
+	}
+	if len(f.last) > maxAuthFailureAddrs+1 {
+		t.Errorf("%d addresses tracked, want at most %d", len(f.last), maxAuthFailureAddrs+1)
+	}
+}
+
+func TestControlHandlerUnauthenticated(t *testing.T) {
+	defer func(f *authFailures) { controlAuthFailures = f }(controlAuthFailures)
+	controlAuthFailures = newAuthFailures(time.Hour)
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	c := &config.Config{Control: config.ControlConfig{
+		Enabled:  true,
+		AuditLog: auditLog,
+		Tokens:   []config.ControlToken{{Name: "helpdesk", Token: "helpdesk-token-0123456789"}},
+	}}
+	request := func(token string, body string) int {
+		r := httptest.NewRequest("POST", "/api/control", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		controlHandler(w, r, c, log.NewNopLogger())
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		// The body of unauthenticated requests is not read.
+		if code := request("wrong", "not json"); code != http.StatusUnauthorized {
+			t.Fatalf("unauthenticated request: got status %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+	audit, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(audit), "\n"); n != 1 {
+		t.Errorf("got %d audit entries for repeated failed authentications, want 1:\n%s", n, audit)
+	}
+
+	body := `{"target":"` + strings.Repeat("x", maxControlRequestBytes) + `"}`
+	if code := request("helpdesk-token-0123456789", body); code != http.StatusBadRequest {
+		t.Errorf("oversized request: got status %d, want %d", code, http.StatusBadRequest)
+	}
+}
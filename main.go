@@ -23,12 +23,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
@@ -42,18 +43,82 @@ import (
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/mm-dict/pearl-exporter/config"
 	"github.com/mm-dict/pearl-exporter/prober"
+	"github.com/mm-dict/pearl-exporter/schedule"
 )
 
 // Namespace defines the common namespace to be used by all metrics.
 const namespace = "pearl"
 
 var (
-	webConfig     = webflag.AddFlags(kingpin.CommandLine)
-	listenAddress = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9115").String()
+	sc = &config.SafeConfig{
+		C: &config.Config{},
+	}
+
+	serveCommand = kingpin.Command("serve", "Run the exporter.").Default()
+
+	probeCommand      = kingpin.Command("probe", "Probe a device once and print the results. Exits 1 if collectors failed and 2 if the device could not be probed.")
+	probeTarget       = probeCommand.Arg("target", "Device to probe, e.g. https://pearl.local.").Required().String()
+	probeUser         = probeCommand.Flag("user", "User to log in to the device as, the configured credentials are used if empty.").String()
+	probePasswordFile = probeCommand.Flag("password-file", "File holding the password of the user.").String()
+	probeOutput       = probeCommand.Flag("output", "Output format, table or json.").Short('o').Default("table").Enum("table", "json")
+	probeCheckUpdates = probeCommand.Flag("check-updates", "Run the firmware collector, which asks the device to check for firmware updates.").Bool()
+
+	checkCommand                = kingpin.Command("check", "Check a device as a Nagios or Icinga plugin, exiting 0 for OK, 1 for WARNING, 2 for CRITICAL and 3 for UNKNOWN.")
+	checkTarget                 = checkCommand.Arg("target", "Device to check, e.g. https://pearl.local.").Required().String()
+	checkUser                   = checkCommand.Flag("user", "User to log in to the device as, the configured credentials are used if empty.").String()
+	checkPasswordFile           = checkCommand.Flag("password-file", "File holding the password of the user.").String()
+	checkStorageFreeWarning     = checkCommand.Flag("storage-free-warning", "Warn below this percentage of free storage, 0 disables.").Default("20").Float64()
+	checkStorageFreeCritical    = checkCommand.Flag("storage-free-critical", "Critical below this percentage of free storage, 0 disables.").Default("10").Float64()
+	checkCPUTemperatureWarning  = checkCommand.Flag("cpu-temperature-warning", "Warn above this CPU temperature in degrees Celsius, 0 disables.").Default("75").Float64()
+	checkCPUTemperatureCritical = checkCommand.Flag("cpu-temperature-critical", "Critical above this CPU temperature in degrees Celsius, 0 disables.").Default("85").Float64()
+	checkSignal                 = checkCommand.Flag("signal", "Channel that must have a video signal, * for all channels. Repeatable.").Strings()
+	checkRecorders              = checkCommand.Flag("recorder", "Recorder that must be recording. Repeatable.").Strings()
+	checkAudio                  = checkCommand.Flag("audio", "Audio source that must not be silent. Repeatable.").Strings()
+
+	checkConfigCommand     = kingpin.Command("check-config", "Check a configuration file, printing every problem found. Exits 1 if the file is invalid or a target could not be reached.")
+	checkConfigFile        = checkConfigCommand.Arg("file", "Configuration file to check.").Required().String()
+	checkConfigTestConnect = checkConfigCommand.Flag("test-connect", "Connect to every configured target with its credentials.").Bool()
+
+	simulateCommand         = kingpin.Command("simulate", "Serve the REST API of a simulated Pearl, for testing without a device.")
+	simulateListenAddress   = simulateCommand.Flag("listen-address", "The address to serve the simulated device on.").Default("localhost:8080").String()
+	simulateModel           = simulateCommand.Flag("model", "Model of the simulated device.").Default("Pearl Mini").String()
+	simulateDeviceFile      = simulateCommand.Flag("device-file", "YAML file overriding the simulated device, e.g. its sources, channels, recorders and faults.").String()
+	simulateFirmwareVersion = simulateCommand.Flag("firmware-version", "Firmware version of the simulated device.").String()
+	simulateUser            = simulateCommand.Flag("user", "User required to access the simulated device, none if empty.").String()
+	simulatePassword        = simulateCommand.Flag("password", "Password of the user.").String()
+	simulateLatency         = simulateCommand.Flag("latency", "Delay every response by this long.").Duration()
+	simulateFaults          = simulateCommand.Flag("fault", "Break requests to paths starting with PATH, as PATH=STATUS, e.g. /api/system/status=500 or =401, or PATH=malformed for truncated JSON. Repeatable.").Strings()
+	simulateNoSignal        = simulateCommand.Flag("no-signal", "Source without a signal, e.g. D2P0.hdmi-a. Repeatable.").Strings()
+
+	configFile     = kingpin.Flag("config.file", "Pearl exporter configuration file.").Default("").String()
+	webConfig      = webflag.AddFlags(kingpin.CommandLine)
+	listenAddress  = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9115").String()
+	sampleInterval = kingpin.Flag("sampler.interval", "Interval at which probed targets are sampled between scrapes for silence and signal loss, 0 disables sampling.").Default("5s").Duration()
+	sampleExpiry   = kingpin.Flag("sampler.expiry", "Stop sampling a target after it has not been probed for this long.").Default("10m").Duration()
+	recordDir      = kingpin.Flag("record-dir", "Store every response of the device API in this directory, with a directory per target and credentials redacted.").String()
+	replayDir      = kingpin.Flag("replay-dir", "Serve the device API from the responses stored by --record-dir in this directory instead of contacting the devices.").String()
+
+	sampler *prober.Sampler
+
+	firmwareUpdateChecker = prober.NewFirmwareUpdateChecker()
+	fleetFirmware         = newFirmwareReport()
+	previewAnalyzer       = prober.NewPreviewAnalyzer()
+	resolutionTracker     = prober.NewResolutionTracker()
+	modelDetector         = prober.NewModelDetector()
+	schedules             = schedule.NewStore(log.NewNopLogger())
+	missedRecordings      = schedule.NewTracker()
+	maintenance           = newMaintenanceWindows()
+
+	probesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pearl_exporter",
+		Name:      "probes_rejected_total",
+		Help:      "Number of probe requests rejected because the target is not allowed",
+	}, []string{"reason"})
 )
 
-func probeHandler(w http.ResponseWriter, r *http.Request, logger log.Logger) {
+func probeHandler(w http.ResponseWriter, r *http.Request, c *config.Config, logger log.Logger) {
 
 	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
@@ -65,51 +130,38 @@ func probeHandler(w http.ResponseWriter, r *http.Request, logger log.Logger) {
 		Name:      "probe_duration_seconds",
 		Help:      "Returns how long the probe took to complete in seconds",
 	})
-	probeInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "system_info",
-		Help:      "Returns system info for the probed device",
-	}, []string{"firmware_version", "firmware_update_availability", "uptime"})
-	probeStorageGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	probeMaintenanceGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
-		Name:      "storage",
-		Help:      "Returns the current status for the storage devices attached",
-	}, []string{"type"})
-	probeCpuGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "maintenance_active",
+		Help:      "Returns whether the target is in a maintenance window",
+	}, []string{"target"})
+	probeDeviceInfoGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
-		Name:      "cpu_info",
-		Help:      "Returns information regarding the systems cpu load and temperature",
-	}, []string{"type"})
-	probeCpuTempGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "cpu_temp",
-		Help:      "Current temperature for the CPU",
-	})
-	probeRecorderGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: namespace,
-		Name:      "recorder_info",
-		Help:      "Returns information regarding the configured recorders",
-	}, []string{"id"})
-	probeChannelsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "device_info",
+		Help:      "Returns the product name, serial number and detected model profile of the device",
+	}, []string{"product", "serial", "model"})
+
+	probeCompatibilityGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
-		Name:      "channels_info",
-		Help:      "Returns information regarding the configured channels and their publishers",
-	}, []string{"id", "status", "type"})
-	probeSDIStatusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "api_compatibility",
+		Help:      "Returns the compatibility state of every API endpoint requested from the device with its firmware",
+	}, []string{"endpoint", "status"})
+
+	probeAudioSilenceCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
-		Name:      "sdi_status",
-		Help:      "Returns information regarding the SDI channel, sets the value to the current fps",
-	}, []string{"resolution"})
-	probeHDMIStatusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "audio_silence_seconds_total",
+		Help:      "Returns how long the audio source has been silent while sampled between scrapes",
+	}, []string{"source"})
+	probeSignalLossSecondsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
-		Name:      "hdmi_status",
-		Help:      "Returns information regarding the HDMI channel, sets the value to the current fps",
-	}, []string{"resolution"})
-	probeRCAStatusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "video_signal_loss_seconds_total",
+		Help:      "Returns how long the channel has had no video signal while sampled between scrapes",
+	}, []string{"channel"})
+	probeSignalLossEventsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
-		Name:      "rca_audio_status",
-		Help:      "Returns the current audio levels for the RCA/line in  audio input",
-	}, []string{"channel", "type"})
+		Name:      "video_signal_loss_events_total",
+		Help:      "Returns how often the channel lost its video signal while sampled between scrapes",
+	}, []string{"channel"})
 
 	params := r.URL.Query()
 	target := params.Get("target")
@@ -120,105 +172,111 @@ func probeHandler(w http.ResponseWriter, r *http.Request, logger log.Logger) {
 		return
 	}
 
-	level.Info(logger).Log("msg", "Beginning epiphan pearl probe with username "+user+" and password "+password)
+	allowed, err := c.TargetAllowed(target)
+	if err != nil {
+		probesRejected.WithLabelValues("invalid_target").Inc()
+		level.Warn(logger).Log("msg", "Rejecting probe, unable to check target against allowlist", "target", target, "err", err)
+		http.Error(w, fmt.Sprintf("Unable to check target %q: %s", target, err), http.StatusForbidden)
+		return
+	}
+	if !allowed {
+		probesRejected.WithLabelValues("not_allowed").Inc()
+		level.Warn(logger).Log("msg", "Rejecting probe for target not in allowlist", "target", target, "remote_addr", r.RemoteAddr)
+		http.Error(w, fmt.Sprintf("Target %q is not allowed", target), http.StatusForbidden)
+		return
+	}
+	if user == "" {
+		var ok bool
+		if user, password, ok = c.ProbeCredentials(target); !ok {
+			level.Warn(logger).Log("msg", "Not sending configured credentials to target not listed under targets without allowed_targets", "target", target)
+		}
+	}
+
+	level.Info(logger).Log("msg", "Beginning epiphan pearl probe", "user", user)
 
 	start := time.Now()
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(probeSuccessGauge)
 	registry.MustRegister(probeDurationGauge)
-	registry.MustRegister(probeInfoGauge)
-	registry.MustRegister(probeStorageGauge)
-	registry.MustRegister(probeRecorderGauge)
-	registry.MustRegister(probeChannelsGauge)
-	registry.MustRegister(probeCpuGauge)
-	registry.MustRegister(probeCpuTempGauge)
-	registry.MustRegister(probeSDIStatusGauge)
-	registry.MustRegister(probeHDMIStatusGauge)
-	registry.MustRegister(probeRCAStatusGauge)
+	registry.MustRegister(probeMaintenanceGauge)
+
+	// Failures of targets in maintenance are expected and only logged at
+	// debug level.
+	inMaintenance := maintenance.active(c, target)
+	probeMaintenanceGauge.WithLabelValues(target).Set(float64(prober.Bool2int(inMaintenance)))
 
 	level.Info(logger).Log("msg", "Probing target : "+target)
-	firmwareVersion, firmwareVersionError := prober.GetFirmwareVersion(target, user, password)
-	systemInfo, systemInfoError := prober.GetSystemInfo(target, user, password)
-	storageInfo, storageInfoError := prober.GetStorageInfo(target, user, password)
-	channelInfo, channelInfoError := prober.GetChannelInfo(target, user, password)
-	updateInfo, updateInfoError := prober.GetFirmwareUpdateAvailability(target, user, password)
-	recorderInfo, recorderInfoError := prober.GetRecorderInfo(target, user, password)
-	sdiInfo, sdiInfoError := prober.GetSDIStatus(target, user, password)
-	hdmiInfo, hdmiInfoError := prober.GetHDMIStatus(target, user, password)
-	rcaInfo, rcaInfoError := prober.GetRCAVolumeStatus(target, user, password)
-	duration := time.Since(start).Seconds()
-
-	probeDurationGauge.Set(duration)
-	if firmwareVersionError != nil {
+	p, err := newProbe(target, user, password, c, logger)
+	if err != nil {
 		probeSuccessGauge.Set(0)
-		level.Info(logger).Log("msg", "Probe failed", "duration_seconds", duration)
+		duration := time.Since(start).Seconds()
+		probeDurationGauge.Set(duration)
+		failed := level.Info(logger)
+		if inMaintenance {
+			failed = level.Debug(logger)
+		}
+		failed.Log("msg", "Probe failed", "duration_seconds", duration, "maintenance", inMaintenance)
 	} else {
 		probeSuccessGauge.Set(1)
-		if updateInfoError == nil {
-			probeInfoGauge.With(prometheus.Labels{"firmware_version": firmwareVersion.Result, "firmware_update_availability": updateInfo.Result.Status, "uptime": strconv.FormatInt(int64(systemInfo.Result.Uptime), 10)}).Set(1)
+		if p.device != nil {
+			registry.MustRegister(probeDeviceInfoGauge)
+			probeDeviceInfoGauge.With(prometheus.Labels{"product": p.device.Result.Product, "serial": p.device.Result.Serial, "model": p.profile.Name}).Set(1)
 		}
 
-		if systemInfoError == nil {
-			probeCpuGauge.WithLabelValues("load").Add(float64(systemInfo.Result.CpuLoad))
-			probeCpuGauge.WithLabelValues("load_high").Add(float64(prober.Bool2int(systemInfo.Result.CpuLoadHigh)))
-			probeCpuTempGauge.Set(float64(systemInfo.Result.Cputemp))
+		p.maintenance = inMaintenance
+		runCollectors(p, registry)
+		// Only targets that answered are sampled, so requests for arbitrary
+		// targets do not leave samplers behind.
+		if sampler != nil {
+			sampler.Track(target, user, password, p.audioSourceIds(), c.SilenceThreshold(target))
 		}
 
-		if storageInfoError == nil {
-			probeStorageGauge.WithLabelValues("total").Add(float64(storageInfo.Result.Total))
-			probeStorageGauge.WithLabelValues("free").Add(float64(storageInfo.Result.Free))
-		}
-
-		if channelInfoError == nil {
-			for key := range channelInfo.Result {
-				probeChannelsGauge.With(prometheus.Labels{"id": channelInfo.Result[key].Id,
-					"status": channelInfo.Result[key].Status.State, "type": "nosignal"}).Set(float64(channelInfo.Result[key].Status.Nosignal))
-				probeChannelsGauge.With(prometheus.Labels{"id": channelInfo.Result[key].Id,
-					"status": channelInfo.Result[key].Status.State, "type": "bitrate"}).Set(float64(channelInfo.Result[key].Status.Bitrate))
-				probeChannelsGauge.With(prometheus.Labels{"id": channelInfo.Result[key].Id,
-					"status": channelInfo.Result[key].Status.State, "type": "duration"}).Set(float64(channelInfo.Result[key].Status.Duration))
+		registry.MustRegister(probeCompatibilityGauge)
+		for endpoint, state := range prober.Compatibility.Snapshot(target) {
+			for _, s := range prober.CompatibilityStates {
+				probeCompatibilityGauge.WithLabelValues(endpoint, s).Set(float64(prober.Bool2int(s == state)))
 			}
 		}
 
-		if recorderInfoError == nil {
-			for key := range recorderInfo.Result {
-				if recorderInfo.Result[key].Status.State == "stopped" {
-					probeRecorderGauge.With(prometheus.Labels{"id": recorderInfo.Result[key].Id}).Set(0)
-				} else {
-					probeRecorderGauge.With(prometheus.Labels{"id": recorderInfo.Result[key].Id}).Set(1)
-				}
-			}
-		}
-
-		if sdiInfoError == nil {
-			probeSDIStatusGauge.With(prometheus.Labels{"resolution": sdiInfo.Result[0].Status.Video.Resolution}).Set(float64(sdiInfo.Result[0].Status.Video.Actual_fps))
-		} else {
-			fmt.Println(sdiInfoError)
-		}
-
-		if hdmiInfoError == nil {
-			probeHDMIStatusGauge.With(prometheus.Labels{"resolution": hdmiInfo.Result[0].Status.Video.Resolution}).Set(float64(hdmiInfo.Result[0].Status.Video.Actual_fps))
-		} else {
-			fmt.Println(hdmiInfoError)
-		}
+		duration := time.Since(start).Seconds()
+		probeDurationGauge.Set(duration)
+		level.Info(logger).Log("msg", "Probe succeeded", "duration_seconds", duration)
+	}
 
-		if rcaInfoError == nil {
-			probeRCAStatusGauge.With(prometheus.Labels{"channel": "left", "type": "peak"}).Set(float64(rcaInfo.Result.Peak[0]))
-			probeRCAStatusGauge.With(prometheus.Labels{"channel": "right", "type": "peak"}).Set(float64(rcaInfo.Result.Peak[1]))
-			probeRCAStatusGauge.With(prometheus.Labels{"channel": "left", "type": "rms"}).Set(float64(rcaInfo.Result.Rms[0]))
-			probeRCAStatusGauge.With(prometheus.Labels{"channel": "right", "type": "rms"}).Set(float64(rcaInfo.Result.Rms[1]))
-		} else {
-			fmt.Println(rcaInfoError)
+	if sampler != nil {
+		if audio, video, ok := sampler.Snapshot(target); ok {
+			registry.MustRegister(probeAudioSilenceCounter)
+			registry.MustRegister(probeSignalLossSecondsCounter)
+			registry.MustRegister(probeSignalLossEventsCounter)
+			for source, state := range audio {
+				probeAudioSilenceCounter.WithLabelValues(source).Add(state.SilenceSeconds)
+			}
+			for channel, state := range video {
+				probeSignalLossSecondsCounter.WithLabelValues(channel).Add(state.SignalLossSeconds)
+				probeSignalLossEventsCounter.WithLabelValues(channel).Add(state.SignalLossEvents)
+			}
 		}
-		level.Info(logger).Log("msg", "Probe succeeded", "duration_seconds", duration)
 	}
 
 	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 	h.ServeHTTP(w, r)
 }
 
+// untrackDisallowedTargets stops sampling the targets the config no longer
+// allows.
+func untrackDisallowedTargets(c *config.Config) {
+	if sampler == nil {
+		return
+	}
+	sampler.Retain(func(target string) bool {
+		allowed, err := c.TargetAllowed(target)
+		return err == nil && allowed
+	})
+}
+
 func init() {
 	prometheus.MustRegister(version.NewCollector("pearl_exporter"))
+	prometheus.MustRegister(probesRejected)
 }
 
 func main() {
@@ -229,7 +287,60 @@ func run() int {
 	kingpin.CommandLine.UsageWriter(os.Stdout)
 	kingpin.Version(version.Print("pearl_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	if *recordDir != "" && *replayDir != "" {
+		fmt.Fprintln(os.Stderr, "--record-dir and --replay-dir are mutually exclusive")
+		return 1
+	}
+	if *recordDir != "" {
+		prober.RecordResponses(*recordDir)
+	}
+	if *replayDir != "" {
+		prober.ReplayResponses(*replayDir)
+	}
+
+	switch command {
+	case checkConfigCommand.FullCommand():
+		return runCheckConfigCommand(*checkConfigFile, *checkConfigTestConnect)
+	case simulateCommand.FullCommand():
+		logger := log.NewLogfmtLogger(os.Stdout)
+		logger = level.NewFilter(logger, level.AllowInfo())
+		return runSimulateCommand(simulateOptions{
+			listenAddress:   *simulateListenAddress,
+			model:           *simulateModel,
+			deviceFile:      *simulateDeviceFile,
+			firmwareVersion: *simulateFirmwareVersion,
+			user:            *simulateUser,
+			password:        *simulatePassword,
+			latency:         *simulateLatency,
+			faults:          *simulateFaults,
+			noSignal:        *simulateNoSignal,
+		}, logger)
+	case probeCommand.FullCommand(), checkCommand.FullCommand():
+		// Only problems are logged, to stderr, to keep the output clean.
+		logger := log.NewLogfmtLogger(os.Stderr)
+		logger = level.NewFilter(logger, level.AllowWarn())
+		c, err := config.LoadFile(*configFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "err", err)
+			return exitUnknown
+		}
+		prober.TLSConfig = c.TLS
+		schedules = schedule.NewStore(logger)
+		if command == checkCommand.FullCommand() {
+			return runCheckCommand(*checkTarget, *checkUser, *checkPasswordFile, checkThresholds{
+				storageFreeWarning:     *checkStorageFreeWarning,
+				storageFreeCritical:    *checkStorageFreeCritical,
+				cpuTemperatureWarning:  *checkCPUTemperatureWarning,
+				cpuTemperatureCritical: *checkCPUTemperatureCritical,
+				signal:                 *checkSignal,
+				recorders:              *checkRecorders,
+				audio:                  *checkAudio,
+			}, c, logger)
+		}
+		return runProbeCommand(*probeTarget, *probeUser, *probePasswordFile, *probeOutput, *probeCheckUpdates, c, logger)
+	}
 
 	logger := log.NewLogfmtLogger(os.Stdout)
 	logger = level.NewFilter(logger, level.AllowInfo())
@@ -238,13 +349,82 @@ func run() int {
 	level.Info(logger).Log("msg", "Starting pearl_exporter", "version", version.Info())
 	level.Info(logger).Log("build_context", version.BuildContext())
 
+	if err := sc.ReloadConfig(*configFile, logger); err != nil {
+		level.Error(logger).Log("msg", "Error loading config", "err", err)
+		return 1
+	}
+
+	prober.Compatibility = prober.NewCompatibilityTracker(logger)
+	schedules = schedule.NewStore(logger)
+	prober.TLSConfig = func(target string) *tls.Config {
+		return sc.Get().TLS(target)
+	}
+	prober.AddressAllowed = func(host string, ip net.IP) bool {
+		return sc.Get().AddressAllowed(host, ip)
+	}
+
+	if *sampleInterval > 0 {
+		sampler = prober.NewSampler(*sampleInterval, *sampleExpiry, logger)
+	}
+
+	hup := make(chan os.Signal, 1)
+	reloadCh := make(chan chan error)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := sc.ReloadConfig(*configFile, logger); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+					continue
+				}
+				prober.ResetClients()
+				untrackDisallowedTargets(sc.Get())
+				level.Info(logger).Log("msg", "Reloaded config file")
+			case rc := <-reloadCh:
+				if err := sc.ReloadConfig(*configFile, logger); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+					rc <- err
+				} else {
+					prober.ResetClients()
+					untrackDisallowedTargets(sc.Get())
+					level.Info(logger).Log("msg", "Reloaded config file")
+					rc <- nil
+				}
+			}
+		}
+	}()
+
 	reg := prometheus.NewRegistry()
 
 	reg.MustRegister(collectors.NewBuildInfoCollector())
 
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+			return
+		}
+
+		rc := make(chan error)
+		reloadCh <- rc
+		if err := <-rc; err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
 	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
-		probeHandler(w, r, logger)
+		probeHandler(w, r, sc.Get(), logger)
+	})
+	http.Handle("/report/firmware", fleetFirmware)
+	http.HandleFunc("/webhook/alertmanager", func(w http.ResponseWriter, r *http.Request) {
+		webhookHandler(w, r, sc.Get(), logger)
+	})
+	http.HandleFunc("/api/control", func(w http.ResponseWriter, r *http.Request) {
+		controlHandler(w, r, sc.Get(), logger)
+	})
+	http.HandleFunc("/api/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		maintenanceHandler(w, r, sc.Get(), logger)
 	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
@@ -253,6 +433,8 @@ func run() int {
     <body>
     <h1>Pearl Exporter</h1>
     <p><a href="probe?target=pearl.local">Probe pearl.local for epiphan pearl metrics</a></p>
+    <p><a href="report/firmware">Firmware report</a> (<a href="report/firmware?format=csv">CSV</a>)</p>
+    <p><a href="api/maintenance">Maintenance windows</a></p>
     <p><a href="metrics">Metrics</a></p>`))
 	})
 
@@ -0,0 +1,281 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/mm-dict/pearl-exporter/config"
+)
+
+// maxMaintenanceWindows limits the windows that can be added through the
+// API, as they are kept in memory.
+const maxMaintenanceWindows = 100
+
+// maintenanceWindows holds the maintenance windows added through the API.
+// They are kept in memory only; windows that must survive a restart belong
+// in the config file.
+type maintenanceWindows struct {
+	mtx     sync.Mutex
+	windows map[string]config.MaintenanceWindow
+}
+
+// maintenanceStatus is a maintenance window as listed by the API.
+type maintenanceStatus struct {
+	config.MaintenanceWindow
+	Source string `json:"source"`
+	Active bool   `json:"active"`
+}
+
+func newMaintenanceWindows() *maintenanceWindows {
+	return &maintenanceWindows{
+		windows: map[string]config.MaintenanceWindow{},
+	}
+}
+
+// list returns the windows that have not expired yet.
+func (m *maintenanceWindows) list() []config.MaintenanceWindow {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.expire(time.Now())
+	windows := make([]config.MaintenanceWindow, 0, len(m.windows))
+	for _, w := range m.windows {
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// expire removes the windows that have ended. m.mtx must be held.
+func (m *maintenanceWindows) expire(now time.Time) {
+	for id, w := range m.windows {
+		if w.Expired(now) {
+			delete(m.windows, id)
+		}
+	}
+}
+
+// active reports whether a maintenance window from the config or the API
+// applies to the target now.
+func (m *maintenanceWindows) active(c *config.Config, target string) bool {
+	now := time.Now()
+	var tc *config.TargetConfig
+	if t, ok := c.LookupTarget(target); ok {
+		tc = &t
+	}
+	for _, w := range append(c.MaintenanceWindows, m.list()...) {
+		if w.Active(now) && w.Matches(target, tc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *maintenanceWindows) add(c *config.Config, w config.MaintenanceWindow) (config.MaintenanceWindow, error) {
+	if err := w.Validate(); err != nil {
+		return w, err
+	}
+	now := time.Now()
+	if w.Expired(now) {
+		return w, fmt.Errorf("maintenance window %q has already ended", w.ID)
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.expire(now)
+	if len(m.windows) >= maxMaintenanceWindows {
+		return w, fmt.Errorf("at most %d maintenance windows can be added through the API", maxMaintenanceWindows)
+	}
+	if w.ID == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return w, err
+		}
+		w.ID = hex.EncodeToString(id)
+	}
+	if _, ok := m.windows[w.ID]; ok {
+		return w, fmt.Errorf("maintenance window %q already exists", w.ID)
+	}
+	for _, cw := range c.MaintenanceWindows {
+		if cw.ID == w.ID {
+			return w, fmt.Errorf("maintenance window %q already exists in the config file", w.ID)
+		}
+	}
+	m.windows[w.ID] = w
+	return w, nil
+}
+
+func (m *maintenanceWindows) get(id string) (config.MaintenanceWindow, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	w, ok := m.windows[id]
+	return w, ok
+}
+
+func (m *maintenanceWindows) remove(id string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, ok := m.windows[id]
+	delete(m.windows, id)
+	return ok
+}
+
+// maintenanceHandler lists maintenance windows on GET, adds a window given as
+// JSON on POST and removes the window given by the id parameter on DELETE.
+// It is part of the control API: requests need a bearer token with a role
+// granting maintenance, on every target of the windows added or removed.
+// Additions and removals are written to the audit log.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request, c *config.Config, logger log.Logger) {
+	if !c.Control.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := c.Control.Authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Missing or unknown bearer token", http.StatusUnauthorized)
+		return
+	}
+	if !c.Control.Grants(token, config.ActionMaintenance) {
+		http.Error(w, fmt.Sprintf("Token %q may not manage maintenance windows", token.Name), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		now := time.Now()
+		statuses := []maintenanceStatus{}
+		for _, window := range c.MaintenanceWindows {
+			statuses = append(statuses, maintenanceStatus{window, "config", window.Active(now)})
+		}
+		for _, window := range maintenance.list() {
+			statuses = append(statuses, maintenanceStatus{window, "api", window.Active(now)})
+		}
+		sort.SliceStable(statuses, func(i, j int) bool {
+			return statuses[i].ID < statuses[j].ID
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+		return
+	}
+
+	entry := auditEntry{Time: time.Now(), Token: token.Name, RemoteAddr: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.RemoteAddr = host
+	}
+	fail := func(status int, result string, err error) {
+		entry.Time = time.Now()
+		entry.Result = result
+		entry.Error = err.Error()
+		controlActions.WithLabelValues(entry.Action, result).Inc()
+		if auditErr := controlAudit.write(c.Control.AuditLog, entry); auditErr != nil {
+			level.Error(logger).Log("msg", "Unable to write audit log", "file", c.Control.AuditLog, "err", auditErr)
+		}
+		level.Info(logger).Log("msg", "Maintenance request", "audit", "control", "token", entry.Token, "remote_addr", entry.RemoteAddr, "action", entry.Action, "result", result, "err", err)
+		http.Error(w, err.Error(), status)
+	}
+	// audit records a change, which is undone or not made when the audit log
+	// cannot be written.
+	audit := func(window config.MaintenanceWindow) bool {
+		entry.Time = time.Now()
+		entry.Target = strings.Join(window.Targets, ",")
+		entry.Window = &window
+		entry.Result = "success"
+		if err := controlAudit.write(c.Control.AuditLog, entry); err != nil {
+			level.Error(logger).Log("msg", "Unable to write audit log, refusing maintenance request", "file", c.Control.AuditLog, "err", err)
+			controlActions.WithLabelValues(entry.Action, "failed").Inc()
+			http.Error(w, "Unable to write audit log", http.StatusInternalServerError)
+			return false
+		}
+		controlActions.WithLabelValues(entry.Action, "success").Inc()
+		level.Info(logger).Log("msg", "Maintenance request", "audit", "control", "token", entry.Token, "remote_addr", entry.RemoteAddr, "action", entry.Action, "window", window.ID, "result", "success")
+		return true
+	}
+
+	if r.Method == http.MethodPost {
+		entry.Action = "maintenance_add"
+		var window config.MaintenanceWindow
+		r.Body = http.MaxBytesReader(w, r.Body, maxControlRequestBytes)
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			fail(http.StatusBadRequest, "invalid", fmt.Errorf("invalid maintenance window: %s", err))
+			return
+		}
+		entry.Window = &window
+		if !c.MaintenanceAuthorized(token, &window) {
+			fail(http.StatusForbidden, "forbidden", fmt.Errorf("token %q may not add maintenance window %q for its targets", token.Name, window.ID))
+			return
+		}
+		window, err := maintenance.add(c, window)
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid", err)
+			return
+		}
+		if !audit(window) {
+			maintenance.remove(window.ID)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(window)
+		return
+	}
+
+	entry.Action = "maintenance_remove"
+	id := r.URL.Query().Get("id")
+	window, ok := maintenance.get(id)
+	if !ok {
+		fail(http.StatusNotFound, "invalid", fmt.Errorf("no maintenance window %q added through the API", id))
+		return
+	}
+	if !c.MaintenanceAuthorized(token, &window) {
+		fail(http.StatusForbidden, "forbidden", fmt.Errorf("token %q may not remove maintenance window %q for its targets", token.Name, id))
+		return
+	}
+	if !audit(window) {
+		return
+	}
+	maintenance.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
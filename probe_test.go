@@ -0,0 +1,492 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/prober"
+	"github.com/mm-dict/pearl-exporter/schedule"
+)
+
+var update = flag.Bool("update", false, "Write the probe output to the golden files.")
+
+// volatileMetrics change with every probe. Their values are left out of the
+// golden files.
+var volatileMetrics = []string{
+	"pearl_clock_offset_seconds",
+	"pearl_clock_round_trip_seconds",
+	"pearl_firmware_update_last_check_timestamp_seconds",
+	"pearl_probe_duration_seconds",
+}
+
+// TestProbeGolden probes a device serving the responses in
+// testdata/fixtures/<case>, as stored by --record-dir, and compares the
+// metrics to testdata/golden/<case>.prom. Run with -update after changing
+// the metrics on purpose and review the diff of the golden files.
+func TestProbeGolden(t *testing.T) {
+	cases, err := os.ReadDir(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range cases {
+		if !fixture.IsDir() {
+			continue
+		}
+		name := fixture.Name()
+		t.Run(name, func(t *testing.T) {
+			device := httptest.NewServer(fixtureHandler(name))
+			defer device.Close()
+			resetProbeState()
+
+			c, err := config.LoadFile("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := goldenProbe(t, device.URL, c)
+			// Probing again must give the same result, the first probe
+			// caches what it learned about the target.
+			if again := goldenProbe(t, device.URL, c); again != got {
+				t.Errorf("second probe differs from the first:\n%s", lineDiff(got, again))
+			}
+
+			golden := filepath.Join("testdata", "golden", name+".prom")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s, run the tests with -update to create it", err)
+			}
+			if got != string(want) {
+				t.Errorf("probe output differs from %s, run the tests with -update and review the diff:\n%s", golden, lineDiff(string(want), got))
+			}
+		})
+	}
+}
+
+// goldenProbe probes the target and returns the normalized metrics.
+func goldenProbe(t *testing.T, target string, c *config.Config) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(target), nil)
+	rec := httptest.NewRecorder()
+	probeHandler(rec, req, c, log.NewNopLogger())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("probe returned %d: %s", rec.Code, rec.Body.String())
+	}
+	return normalizeProbeOutput(rec.Body.String(), target)
+}
+
+// fixtureHandler serves the responses recorded for a case.
+func fixtureHandler(name string) http.Handler {
+	replay := &prober.ReplayTransport{Dir: filepath.Join("testdata", "fixtures")}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The responses of a case are stored as those of the target name.
+		r.URL.Host = name
+		resp, err := replay.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+}
+
+// resetProbeState forgets what earlier probes learned about their targets.
+func resetProbeState() {
+	prober.Compatibility = prober.NewCompatibilityTracker(log.NewNopLogger())
+	firmwareUpdateChecker = prober.NewFirmwareUpdateChecker()
+	fleetFirmware = newFirmwareReport()
+	previewAnalyzer = prober.NewPreviewAnalyzer()
+	resolutionTracker = prober.NewResolutionTracker()
+	modelDetector = prober.NewModelDetector()
+}
+
+// normalizeProbeOutput replaces the address of the test server and the
+// values of volatile metrics.
+func normalizeProbeOutput(output string, target string) string {
+	output = strings.ReplaceAll(output, target, "http://pearl.test")
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		for _, name := range volatileMetrics {
+			if strings.HasPrefix(line, name+" ") || strings.HasPrefix(line, name+"{") {
+				lines[i] = line[:strings.LastIndex(line, " ")] + " VOLATILE"
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lineDiff lists the lines only in want or only in got.
+func lineDiff(want string, got string) string {
+	wantLines := map[string]bool{}
+	for _, line := range strings.Split(want, "\n") {
+		wantLines[line] = true
+	}
+	gotLines := map[string]bool{}
+	var diff []string
+	for _, line := range strings.Split(got, "\n") {
+		gotLines[line] = true
+		if !wantLines[line] {
+			diff = append(diff, "+ "+line)
+		}
+	}
+	for _, line := range strings.Split(want, "\n") {
+		if !gotLines[line] {
+			diff = append(diff, "- "+line)
+		}
+	}
+	return strings.Join(diff, "\n")
+}
+
+func TestRecordingMissed(t *testing.T) {
+	start := time.Date(2022, 10, 17, 9, 0, 0, 0, time.UTC)
+	grace := 5 * time.Minute
+	tests := []struct {
+		duration time.Duration
+		at       time.Duration
+		want     bool
+	}{
+		{time.Hour, 2 * time.Minute, false},
+		{time.Hour, 6 * time.Minute, true},
+		{time.Hour, 54 * time.Minute, true},
+		{time.Hour, 56 * time.Minute, false},
+		{time.Hour, 61 * time.Minute, false},
+		// Occurrences shorter than twice the grace period are checked
+		// in their middle half.
+		{8 * time.Minute, time.Minute, false},
+		{8 * time.Minute, 4 * time.Minute, true},
+		{8 * time.Minute, 7 * time.Minute, false},
+		{2 * time.Minute, time.Minute, true},
+	}
+	for _, test := range tests {
+		o := schedule.Occurrence{Start: start, End: start.Add(test.duration)}
+		if got := recordingMissed(o, start.Add(test.at), grace); got != test.want {
+			t.Errorf("recordingMissed() %s into a %s occurrence = %t, want %t", test.at, test.duration, got, test.want)
+		}
+	}
+}
+
+func TestCollectNetwork(t *testing.T) {
+	tests := []struct {
+		name       string
+		interfaces string
+		want       string
+	}{
+		{
+			name:       "counters",
+			interfaces: `{"id":"eth0","link":true,"speed":1000,"duplex":"full","dhcp":true,"ipv4":{"address":"10.0.0.7","netmask":"255.255.255.0","gateway":"10.0.0.1"},"rx_bytes":1024,"tx_bytes":2048,"rx_errors":0,"tx_errors":3}`,
+			want: `
+pearl_network_info{address="10.0.0.7",duplex="full",gateway="10.0.0.1",interface="eth0",method="dhcp",netmask="255.255.255.0"} 1
+pearl_network_receive_bytes_total{interface="eth0"} 1024
+pearl_network_receive_errs_total{interface="eth0"} 0
+pearl_network_speed_bytes{interface="eth0"} 1.25e+08
+pearl_network_transmit_bytes_total{interface="eth0"} 2048
+pearl_network_transmit_errs_total{interface="eth0"} 3
+pearl_network_up{interface="eth0"} 1
+`,
+		},
+		{
+			// Older firmware reports no counters, a link without a cable no
+			// speed.
+			name:       "no counters",
+			interfaces: `{"id":"eth0","link":true,"speed":100},{"id":"eth1","link":false}`,
+			want: `
+pearl_network_info{address="",duplex="",gateway="",interface="eth0",method="static",netmask=""} 1
+pearl_network_info{address="",duplex="",gateway="",interface="eth1",method="static",netmask=""} 1
+pearl_network_speed_bytes{interface="eth0"} 1.25e+07
+pearl_network_up{interface="eth0"} 1
+pearl_network_up{interface="eth1"} 0
+`,
+		},
+		{
+			name:       "no interfaces",
+			interfaces: ``,
+			want:       ``,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"status":"ok","result":[`+test.interfaces+`]}`)
+			}))
+			defer device.Close()
+			resetProbeState()
+
+			p := &probe{target: device.URL, config: &config.Config{}, logger: log.NewNopLogger()}
+			registry := prometheus.NewRegistry()
+			if err := collectNetwork(p, registry); err != nil {
+				t.Fatal(err)
+			}
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range samples(families) {
+				got = append(got, s.String())
+			}
+			if strings.Join(got, "\n") != strings.TrimSpace(test.want) {
+				t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.TrimSpace(test.want))
+			}
+		})
+	}
+}
+
+func TestCollectThermal(t *testing.T) {
+	thresholds := config.ThermalConfig{CPUTemperatureCelsius: 80, SensorTemperatureCelsius: 60, FanMinimumRPM: 1000}
+	tests := []struct {
+		name    string
+		status  string
+		sensors string
+		want    string
+	}{
+		{
+			// The alarms are raised past the thresholds, not at them.
+			name:    "at thresholds",
+			status:  `{"cpuload":20,"cputemp":80}`,
+			sensors: `{"id":"board","name":"Board","type":"temperature","value":60},{"id":"fan1","name":"Fan","type":"fan","value":1000}`,
+			want: `
+pearl_cpu_load_ratio 0.2
+pearl_cpu_temperature_celsius 80
+pearl_fan_speed_rpm{name="Fan",sensor="fan1"} 1000
+pearl_temperature_celsius{name="Board",sensor="board"} 60
+pearl_thermal_alarm{sensor="board"} 0
+pearl_thermal_alarm{sensor="cpu"} 0
+pearl_thermal_alarm{sensor="fan1"} 0
+`,
+		},
+		{
+			name:    "past thresholds",
+			status:  `{"cputemp":81}`,
+			sensors: `{"id":"board","name":"Board","type":"temperature","value":60.5},{"id":"fan1","name":"Fan","type":"fan","value":999}`,
+			want: `
+pearl_cpu_temperature_celsius 81
+pearl_fan_speed_rpm{name="Fan",sensor="fan1"} 999
+pearl_temperature_celsius{name="Board",sensor="board"} 60.5
+pearl_thermal_alarm{sensor="board"} 1
+pearl_thermal_alarm{sensor="cpu"} 1
+pearl_thermal_alarm{sensor="fan1"} 1
+`,
+		},
+		{
+			// Without a CPU temperature there is nothing to raise an alarm
+			// for.
+			name:    "no CPU temperature",
+			status:  `{"cpuload":20}`,
+			sensors: ``,
+			want: `
+pearl_cpu_load_ratio 0.2
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/system/status":
+					io.WriteString(w, `{"status":"ok","result":`+test.status+`}`)
+				case "/api/system/sensors":
+					io.WriteString(w, `{"status":"ok","result":[`+test.sensors+`]}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer device.Close()
+			resetProbeState()
+
+			p := &probe{target: device.URL, config: &config.Config{Thermal: thresholds}, logger: log.NewNopLogger()}
+			registry := prometheus.NewRegistry()
+			if err := collectThermal(p, registry); err != nil {
+				t.Fatal(err)
+			}
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range samples(families) {
+				got = append(got, s.String())
+			}
+			if strings.Join(got, "\n") != strings.TrimSpace(test.want) {
+				t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.TrimSpace(test.want))
+			}
+		})
+	}
+}
+
+func TestCollectCms(t *testing.T) {
+	const (
+		past   = "2020-01-01T09:00:00Z"
+		future = "2099-01-01T00:00:00Z"
+	)
+	recorders := `{"id":"1","status":{"state":"stopped"}},{"id":"2","status":{"state":"started"}}`
+	tests := []struct {
+		name    string
+		status  string
+		events  string
+		wantErr bool
+		want    string
+	}{
+		{
+			name: "no CMS",
+			want: ``,
+		},
+		{
+			name:   "disabled",
+			status: `{"enabled":false,"type":"kaltura","state":"unregistered"}`,
+			want: `
+pearl_cms_enabled 0
+`,
+		},
+		{
+			name:   "upcoming events",
+			status: `{"enabled":true,"type":"kaltura","state":"registered","last_sync":"2020-01-01 08:00:00"}`,
+			events: `{"id":"a","start":"` + future + `","end":"` + future + `"},{"id":"b","start":"2098-01-01T00:00:00Z","end":"` + future + `"},{"id":"c","start":"2019-01-01T09:00:00Z","end":"` + past + `"}`,
+			want: `
+pearl_cms_enabled 1
+pearl_cms_event_not_recording 0
+pearl_cms_event_running 0
+pearl_cms_info{state="registered",type="kaltura"} 1
+pearl_cms_last_sync_timestamp_seconds 1.5778656e+09
+pearl_cms_next_event_start_timestamp_seconds 4.0393728e+09
+pearl_cms_registered 1
+pearl_cms_upcoming_events 2
+`,
+		},
+		{
+			// The assigned recorder is stopped while another one records.
+			name:   "running event on a stopped recorder",
+			status: `{"enabled":true,"type":"opencast","state":"registering"}`,
+			events: `{"id":"a","start":"` + past + `","end":"` + future + `","recorder":"1"}`,
+			want: `
+pearl_cms_enabled 1
+pearl_cms_event_not_recording 1
+pearl_cms_event_running 1
+pearl_cms_info{state="registering",type="opencast"} 1
+pearl_cms_registered 0
+pearl_cms_upcoming_events 0
+`,
+		},
+		{
+			// Without an assigned recorder any recording recorder will do.
+			name:   "running event without recorder",
+			status: `{"enabled":true,"type":"opencast","state":"registered"}`,
+			events: `{"id":"a","start":"` + past + `","end":"` + future + `"}`,
+			want: `
+pearl_cms_enabled 1
+pearl_cms_event_not_recording 0
+pearl_cms_event_running 1
+pearl_cms_info{state="registered",type="opencast"} 1
+pearl_cms_registered 1
+pearl_cms_upcoming_events 0
+`,
+		},
+		{
+			name:   "running event on an unknown recorder",
+			status: `{"enabled":true,"type":"opencast","state":"registered"}`,
+			events: `{"id":"a","start":"` + past + `","end":"` + future + `","recorder":"9"}`,
+			want: `
+pearl_cms_enabled 1
+pearl_cms_event_not_recording 1
+pearl_cms_event_running 1
+pearl_cms_info{state="registered",type="opencast"} 1
+pearl_cms_registered 1
+pearl_cms_upcoming_events 0
+`,
+		},
+		{
+			name:    "unparsable event start",
+			status:  `{"enabled":true,"type":"opencast","state":"registered"}`,
+			events:  `{"id":"a","start":"tomorrow","end":"` + future + `"}`,
+			wantErr: true,
+		},
+		{
+			name:    "unparsable last sync",
+			status:  `{"enabled":true,"type":"opencast","state":"registered","last_sync":"yesterday"}`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/api/cms/status" && test.status != "":
+					io.WriteString(w, `{"status":"ok","result":`+test.status+`}`)
+				case r.URL.Path == "/api/cms/events":
+					io.WriteString(w, `{"status":"ok","result":[`+test.events+`]}`)
+				case r.URL.Path == "/api/recorders/status":
+					io.WriteString(w, `{"status":"ok","result":[`+recorders+`]}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer device.Close()
+			resetProbeState()
+
+			p := &probe{target: device.URL, config: &config.Config{}, logger: log.NewNopLogger()}
+			registry := prometheus.NewRegistry()
+			err := collectCms(p, registry)
+			if test.wantErr {
+				if err == nil {
+					t.Error("collectCms() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range samples(families) {
+				got = append(got, s.String())
+			}
+			if strings.Join(got, "\n") != strings.TrimSpace(test.want) {
+				t.Errorf("got metrics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.TrimSpace(test.want))
+			}
+		})
+	}
+}
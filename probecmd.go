@@ -0,0 +1,282 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/mm-dict/pearl-exporter/config"
+)
+
+// Exit codes of the probe command, matching the plugin conventions of
+// Nagios and Icinga.
+const (
+	exitOK       = 0
+	exitWarning  = 1
+	exitCritical = 2
+	exitUnknown  = 3
+)
+
+// probeReport is the result of the probe command.
+type probeReport struct {
+	Target          string            `json:"target"`
+	Success         bool              `json:"success"`
+	Error           string            `json:"error,omitempty"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	FirmwareVersion string            `json:"firmware_version,omitempty"`
+	Product         string            `json:"product,omitempty"`
+	Serial          string            `json:"serial,omitempty"`
+	Model           string            `json:"model,omitempty"`
+	Collectors      []collectorReport `json:"collectors,omitempty"`
+}
+
+type collectorReport struct {
+	Name            string         `json:"name"`
+	Status          string         `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Samples         []sampleReport `json:"samples,omitempty"`
+}
+
+type sampleReport struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+func (s sampleReport) String() string {
+	if len(s.Labels) == 0 {
+		return fmt.Sprintf("%s %g", s.Name, s.Value)
+	}
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, s.Labels[name]))
+	}
+	return fmt.Sprintf("%s{%s} %g", s.Name, strings.Join(labels, ","), s.Value)
+}
+
+// probeOnce runs the named collectors, or every collector if none are
+// named, against the target, each into a registry of its own so its samples
+// can be reported with it.
+func probeOnce(target string, user string, password string, names []string, c *config.Config, logger log.Logger) probeReport {
+	start := time.Now()
+	report := probeReport{Target: target}
+	p, err := newProbe(target, user, password, c, logger)
+	if err != nil {
+		report.Error = err.Error()
+		report.DurationSeconds = time.Since(start).Seconds()
+		return report
+	}
+	report.Success = true
+	report.FirmwareVersion = p.firmwareVersion
+	report.Model = p.profile.Name
+	if p.device != nil {
+		report.Product = p.device.Result.Product
+		report.Serial = p.device.Result.Serial
+	}
+
+	for _, collector := range probeCollectors {
+		if len(names) > 0 && !containsName(names, collector.name) {
+			continue
+		}
+		registry := prometheus.NewRegistry()
+		result := runCollector(collector, p, registry)
+		cr := collectorReport{Name: result.name, Status: "ok", DurationSeconds: result.duration.Seconds()}
+		switch {
+		case result.skipped:
+			cr.Status = "skipped"
+		case result.err != nil:
+			cr.Status = "failed"
+			cr.Error = result.err.Error()
+		}
+		families, err := registry.Gather()
+		if err != nil && cr.Error == "" {
+			cr.Status = "failed"
+			cr.Error = err.Error()
+		}
+		cr.Samples = samples(families)
+		report.Collectors = append(report.Collectors, cr)
+	}
+	report.DurationSeconds = time.Since(start).Seconds()
+	return report
+}
+
+// commandCollectors returns the collectors the probe and check commands run
+// unless told otherwise: every collector except the firmware collector, which
+// asks the device to check for firmware updates, unless checkUpdates is set.
+// Update checks are cached by the exporter, but not across command runs.
+func commandCollectors(checkUpdates bool) []string {
+	var names []string
+	for _, collector := range probeCollectors {
+		if collector.name == "firmware" && !checkUpdates {
+			continue
+		}
+		names = append(names, collector.name)
+	}
+	return names
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// samples flattens gauges and counters into samples.
+func samples(families []*dto.MetricFamily) []sampleReport {
+	var samples []sampleReport
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			s := sampleReport{Name: family.GetName()}
+			switch {
+			case m.Gauge != nil:
+				s.Value = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				s.Value = m.GetCounter().GetValue()
+			default:
+				continue
+			}
+			if len(m.GetLabel()) > 0 {
+				s.Labels = map[string]string{}
+				for _, label := range m.GetLabel() {
+					s.Labels[label.GetName()] = label.GetValue()
+				}
+			}
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+// exitCode is critical when the device could not be probed and warning
+// when some collectors failed.
+func (r probeReport) exitCode() int {
+	if !r.Success {
+		return exitCritical
+	}
+	for _, c := range r.Collectors {
+		if c.Status == "failed" {
+			return exitWarning
+		}
+	}
+	return exitOK
+}
+
+func (r probeReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r probeReport) writeTable(w io.Writer) error {
+	if !r.Success {
+		_, err := fmt.Fprintf(w, "Probe of %s failed after %s: %s\n", r.Target, formatSeconds(r.DurationSeconds), r.Error)
+		return err
+	}
+	fmt.Fprintf(w, "Target:   %s\nDevice:   %s (model %s, serial %s)\nFirmware: %s\nDuration: %s\n\n",
+		r.Target, r.Product, r.Model, r.Serial, r.FirmwareVersion, formatSeconds(r.DurationSeconds))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTOR\tSTATUS\tLATENCY\tVALUES")
+	for _, c := range r.Collectors {
+		latency := "-"
+		if c.Status != "skipped" {
+			latency = formatSeconds(c.DurationSeconds)
+		}
+		first := c.Error
+		if first == "" && len(c.Samples) > 0 {
+			first = c.Samples[0].String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Status, latency, first)
+		for i, s := range c.Samples {
+			if i == 0 && c.Error == "" {
+				continue
+			}
+			fmt.Fprintf(tw, "\t\t\t%s\n", s)
+		}
+	}
+	return tw.Flush()
+}
+
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+// commandCredentials returns the user given on the command line with the
+// password from the password file, or the configured credentials of the
+// target when no user is given.
+func commandCredentials(target string, user string, passwordFile string, c *config.Config) (string, string, error) {
+	if user == "" {
+		user, password := c.Credentials(target)
+		return user, password, nil
+	}
+	if passwordFile == "" {
+		return user, "", nil
+	}
+	// Password files are read like those of the config file.
+	password, err := config.ReadSecret(passwordFile)
+	if err != nil {
+		return "", "", err
+	}
+	return user, password, nil
+}
+
+// runProbeCommand probes the target once and prints the results. The device
+// is only asked to check for firmware updates if checkUpdates is set.
+func runProbeCommand(target string, user string, passwordFile string, output string, checkUpdates bool, c *config.Config, logger log.Logger) int {
+	user, password, err := commandCredentials(target, user, passwordFile, c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUnknown
+	}
+
+	report := probeOnce(target, user, password, commandCollectors(checkUpdates), c, logger)
+	if output == "json" {
+		err = report.writeJSON(os.Stdout)
+	} else {
+		err = report.writeTable(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %s\n", err)
+		return exitUnknown
+	}
+	return report.exitCode()
+}
@@ -0,0 +1,117 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/simulator"
+)
+
+// updateCheckCounter serves a simulated device and counts the requests
+// asking it to check for firmware updates.
+func updateCheckCounter(t *testing.T) (*httptest.Server, *int32) {
+	t.Helper()
+	device, err := simulator.NewDevice("Pearl Mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.New(device)
+	var checks int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/api/system/firmware/update/control/check" {
+			atomic.AddInt32(&checks, 1)
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	return server, &checks
+}
+
+func TestSystemCollectorDoesNotCheckForUpdates(t *testing.T) {
+	server, checks := updateCheckCounter(t)
+	defer server.Close()
+	resetProbeState()
+	c, err := config.LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := probeOnce(server.URL, "", "", []string{"system"}, c, log.NewNopLogger())
+	if !report.Success {
+		t.Fatalf("probe failed: %s", report.Error)
+	}
+	if n := atomic.LoadInt32(checks); n != 0 {
+		t.Errorf("the system collector asked the device %d times to check for updates", n)
+	}
+}
+
+func TestCommandCollectorsCheckUpdates(t *testing.T) {
+	for _, checkUpdates := range []bool{false, true} {
+		server, checks := updateCheckCounter(t)
+		resetProbeState()
+		c, err := config.LoadFile("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := probeOnce(server.URL, "", "", commandCollectors(checkUpdates), c, log.NewNopLogger())
+		server.Close()
+		if !report.Success {
+			t.Fatalf("probe failed: %s", report.Error)
+		}
+		want := int32(0)
+		if checkUpdates {
+			want = 1
+		}
+		if n := atomic.LoadInt32(checks); n != want {
+			t.Errorf("with check updates %t the device was asked %d times to check for updates, want %d", checkUpdates, n, want)
+		}
+	}
+}
+
+func TestCommandCredentialsPasswordFile(t *testing.T) {
+	c, err := config.LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"secret", "secret\n", "secret\r\n", "  secret \t\n"} {
+		file := filepath.Join(t.TempDir(), "password")
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		user, password, err := commandCredentials("pearl.local", "admin", file, c)
+		if err != nil || user != "admin" || password != "secret" {
+			t.Errorf("password file %q: got %q, %q, %v", content, user, password, err)
+		}
+	}
+	if _, _, err := commandCredentials("pearl.local", "admin", filepath.Join(t.TempDir(), "missing"), c); err == nil {
+		t.Error("a missing password file was accepted")
+	}
+}
@@ -0,0 +1,65 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"fmt"
+	"time"
+)
+
+// deviceDateLayouts are the formats in which devices report their date.
+// Dates without a zone are in UTC.
+var deviceDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// ParseDeviceDate parses the date reported in the system status of a device.
+func ParseDeviceDate(date string) (time.Time, error) {
+	for _, layout := range deviceDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", date)
+}
+
+// ClockOffset estimates how far the clock of a device is ahead of the local
+// clock from a date the device reported in a response to a request sent at
+// start and answered at end. The device is assumed to have read its clock
+// halfway through the round trip. Devices report whole seconds, so the middle
+// of the reported second is used.
+func ClockOffset(date string, start time.Time, end time.Time) (time.Duration, error) {
+	deviceTime, err := ParseDeviceDate(date)
+	if err != nil {
+		return 0, err
+	}
+	if deviceTime.Nanosecond() == 0 {
+		deviceTime = deviceTime.Add(500 * time.Millisecond)
+	}
+	localTime := start.Add(end.Sub(start) / 2)
+	return deviceTime.Sub(localTime), nil
+}
@@ -0,0 +1,92 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDeviceDate(t *testing.T) {
+	want := time.Date(2022, 10, 17, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date string
+		ok   bool
+	}{
+		{"2022-10-17T09:00:00Z", true},
+		{"2022-10-17T11:00:00+02:00", true},
+		{"2022-10-17T09:00:00", true},
+		{"2022-10-17 09:00:00", true},
+		{"Mon, 17 Oct 2022 09:00:00 +0000", true},
+		{"", false},
+		{"17/10/2022 09:00", false},
+		{"2022-10-17", false},
+	}
+	for _, test := range tests {
+		got, err := ParseDeviceDate(test.date)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseDeviceDate(%q) error = %v, want ok %t", test.date, err, test.ok)
+			continue
+		}
+		if test.ok && !got.Equal(want) {
+			t.Errorf("ParseDeviceDate(%q) = %s, want %s", test.date, got, want)
+		}
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	start := time.Date(2022, 10, 17, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		date  string
+		start time.Time
+		end   time.Time
+		want  time.Duration
+	}{
+		// Whole seconds are read as the middle of the second.
+		{"in sync", "2022-10-17T09:00:00Z", start, start.Add(time.Second), 0},
+		{"device ahead", "2022-10-17T09:00:30Z", start, start.Add(time.Second), 30 * time.Second},
+		{"device behind", "2022-10-17T08:59:30Z", start, start.Add(time.Second), -30 * time.Second},
+		{"instant response", "2022-10-17T09:00:00Z", start, start, 500 * time.Millisecond},
+		// A slow response moves the estimate to the middle of the round
+		// trip, whichever second the device read.
+		{"slow response", "2022-10-17T09:00:04Z", start, start.Add(9 * time.Second), 0},
+		{"slow response read early", "2022-10-17T09:00:00Z", start, start.Add(9 * time.Second), -4 * time.Second},
+		{"fractional seconds", "2022-10-17T09:00:00.25Z", start, start.Add(500 * time.Millisecond), 0},
+		{"zone offset", "2022-10-17T11:00:00+02:00", start, start.Add(time.Second), 0},
+		{"no zone", "2022-10-17T09:00:00", start, start.Add(time.Second), 0},
+	}
+	for _, test := range tests {
+		got, err := ClockOffset(test.date, test.start, test.end)
+		if err != nil {
+			t.Errorf("%s: ClockOffset() failed: %s", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: ClockOffset() = %s, want %s", test.name, got, test.want)
+		}
+	}
+	if _, err := ClockOffset("yesterday", start, start); err == nil {
+		t.Error("ClockOffset() accepted an unknown date")
+	}
+}
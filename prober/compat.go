@@ -0,0 +1,394 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Endpoints of the Pearl REST API, as tracked for compatibility.
+const (
+	EndpointFirmwareVersion  = "firmware_version"
+	EndpointDeviceInfo       = "device_info"
+	EndpointFirmwareUpdate   = "firmware_update"
+	EndpointStorage          = "storage"
+	EndpointStorages         = "storages"
+	EndpointSystemStatus     = "system_status"
+	EndpointDateTime         = "datetime"
+	EndpointSensors          = "sensors"
+	EndpointNetwork          = "network"
+	EndpointRecorders        = "recorders"
+	EndpointRecorderControl  = "recorder_control"
+	EndpointPublisherControl = "publisher_control"
+	EndpointReboot           = "reboot"
+	EndpointCmsStatus        = "cms_status"
+	EndpointCmsEvents        = "cms_events"
+	EndpointChannels         = "channels"
+	EndpointChannelEncoding  = "channel_encoding"
+	EndpointChannelLayouts   = "channel_layouts"
+	EndpointChannelPreview   = "channel_preview"
+	EndpointSourceStatus     = "source_status"
+	EndpointSources          = "sources"
+	EndpointAudioLevels      = "audio_levels"
+)
+
+// Compatibility states of an endpoint.
+const (
+	// CompatibilityOK means the last response was decoded by a decoder made
+	// for the firmware version of the device.
+	CompatibilityOK = "ok"
+	// CompatibilityUntested means the last response was decoded, but by the
+	// newest decoder because none is known for the firmware version.
+	CompatibilityUntested = "untested"
+	// CompatibilityUnsupported means the endpoint does not exist for the
+	// firmware version, either according to the decoder table or the device.
+	CompatibilityUnsupported = "unsupported"
+	// CompatibilityDecodeError means the response could not be decoded.
+	CompatibilityDecodeError = "decode_error"
+	// CompatibilityError means the request failed for another reason.
+	CompatibilityError = "error"
+)
+
+// CompatibilityStates lists every compatibility state.
+var CompatibilityStates = []string{CompatibilityOK, CompatibilityUntested, CompatibilityUnsupported, CompatibilityDecodeError, CompatibilityError}
+
+// ErrUnsupported is returned for endpoints that the firmware of the device
+// does not provide.
+var ErrUnsupported = errors.New("endpoint not supported by firmware")
+
+// APIError is returned when the device answers with an error.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("device returned %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("device returned %d", e.StatusCode)
+}
+
+// apiDecoder decodes the responses of an endpoint for the API versions from
+// minVersion up to, but excluding, the next decoder's minVersion.
+type apiDecoder struct {
+	minVersion string
+	decode     func(data []byte, v interface{}) error
+}
+
+// apiDecoders holds the decoders of every endpoint, oldest API version
+// first. Firmware older than the first decoder does not provide the endpoint,
+// firmware of a newer major version than the last decoder is decoded with the
+// last decoder and reported as untested.
+var apiDecoders = map[string][]apiDecoder{
+	EndpointFirmwareVersion:  {{"4.0", decodeJSON}},
+	EndpointDeviceInfo:       {{"4.0", decodeJSON}},
+	EndpointFirmwareUpdate:   {{"4.0", decodeJSON}},
+	EndpointStorage:          {{"4.0", decodeJSON}},
+	EndpointStorages:         {{"4.0", decodeJSON}},
+	EndpointSystemStatus:     {{"4.0", decodeSystemStatusLegacy}, {"4.14", decodeJSON}},
+	EndpointDateTime:         {{"4.0", decodeJSON}},
+	EndpointSensors:          {{"4.0", decodeJSON}},
+	EndpointNetwork:          {{"4.0", decodeJSON}},
+	EndpointRecorders:        {{"4.0", decodeJSON}},
+	EndpointRecorderControl:  {{"4.0", decodeJSON}},
+	EndpointPublisherControl: {{"4.0", decodeJSON}},
+	EndpointReboot:           {{"4.0", decodeJSON}},
+	EndpointCmsStatus:        {{"4.0", decodeJSON}},
+	EndpointCmsEvents:        {{"4.0", decodeJSON}},
+	EndpointChannels:         {{"4.0", decodeJSON}},
+	EndpointChannelEncoding:  {{"4.0", decodeJSON}},
+	EndpointChannelLayouts:   {{"4.0", decodeJSON}},
+	EndpointChannelPreview:   {{"4.0", decodeJPEG}},
+	EndpointSourceStatus:     {{"4.0", decodeJSON}},
+	EndpointSources:          {{"4.0", decodeJSON}},
+	EndpointAudioLevels:      {{"4.0", decodeJSON}},
+}
+
+// versionEndpoints identify the device. They are requested with the newest
+// decoder whatever the firmware version, as the version is read from them:
+// firmware too old for the API still has to be recognized on every probe.
+var versionEndpoints = map[string]bool{
+	EndpointFirmwareVersion: true,
+	EndpointDeviceInfo:      true,
+}
+
+// resourceEndpoints address a single channel or source. A 404 from them means
+// the resource does not exist rather than the endpoint being unsupported.
+var resourceEndpoints = map[string]bool{
+	EndpointRecorderControl:  true,
+	EndpointPublisherControl: true,
+	EndpointChannelEncoding:  true,
+	EndpointChannelLayouts:   true,
+	EndpointChannelPreview:   true,
+	EndpointAudioLevels:      true,
+}
+
+// decodeJSON decodes the common {"status": ..., "result": ...} envelope and
+// turns error statuses into an APIError.
+func decodeJSON(data []byte, v interface{}) error {
+	envelope := struct {
+		Status  string
+		Message string
+	}{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+	if envelope.Status != "" && envelope.Status != "ok" {
+		return &APIError{StatusCode: http.StatusOK, Message: envelope.Message}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeSystemStatusLegacy decodes the system status of firmware before 4.14,
+// which reports the CPU load alarm as cpuload_high.
+func decodeSystemStatusLegacy(data []byte, v interface{}) error {
+	if err := decodeJSON(data, v); err != nil {
+		return err
+	}
+	status, ok := v.(*SystemStatus)
+	if !ok {
+		return fmt.Errorf("cannot decode system status into %T", v)
+	}
+	legacy := struct {
+		Result struct {
+			CpuLoadHigh *bool `json:"cpuload_high"`
+		}
+	}{}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.Result.CpuLoadHigh != nil {
+		status.Result.CpuLoadHigh = legacy.Result.CpuLoadHigh
+	}
+	return nil
+}
+
+func decodeJPEG(data []byte, v interface{}) error {
+	img, ok := v.(*image.Image)
+	if !ok {
+		return fmt.Errorf("cannot decode image into %T", v)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*img = decoded
+	return nil
+}
+
+// CompatibilityTracker remembers the firmware version of every target to
+// select response decoders, and the compatibility state of every endpoint.
+type CompatibilityTracker struct {
+	logger log.Logger
+
+	mtx     sync.Mutex
+	targets map[string]*targetCompatibility
+}
+
+type targetCompatibility struct {
+	firmwareVersion string
+	endpoints       map[string]string
+}
+
+func NewCompatibilityTracker(logger log.Logger) *CompatibilityTracker {
+	return &CompatibilityTracker{
+		logger:  logger,
+		targets: map[string]*targetCompatibility{},
+	}
+}
+
+// Compatibility is the tracker used by all requests to devices.
+var Compatibility = NewCompatibilityTracker(log.NewNopLogger())
+
+// Negotiate records the firmware version of the target. Decoders are selected
+// for this version until a different version is reported, e.g. after a
+// firmware upgrade, which resets the compatibility state of all endpoints.
+func (c *CompatibilityTracker) Negotiate(target string, firmwareVersion string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	t, ok := c.targets[target]
+	if ok && t.firmwareVersion == firmwareVersion {
+		return
+	}
+	if !ok || t.firmwareVersion == "" {
+		level.Info(c.logger).Log("msg", "Selecting decoders for firmware", "target", target, "version", firmwareVersion)
+		if !ok {
+			t = &targetCompatibility{endpoints: map[string]string{}}
+			c.targets[target] = t
+		}
+		t.firmwareVersion = firmwareVersion
+		return
+	}
+	level.Info(c.logger).Log("msg", "Firmware version changed, selecting decoders again", "target", target, "old_version", t.firmwareVersion, "version", firmwareVersion)
+	endpoints := map[string]string{}
+	if state, ok := t.endpoints[EndpointFirmwareVersion]; ok {
+		endpoints[EndpointFirmwareVersion] = state
+	}
+	c.targets[target] = &targetCompatibility{
+		firmwareVersion: firmwareVersion,
+		endpoints:       endpoints,
+	}
+}
+
+// Snapshot returns the compatibility state of every endpoint requested from
+// the target since the firmware version was last negotiated.
+func (c *CompatibilityTracker) Snapshot(target string) map[string]string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	states := map[string]string{}
+	if t, ok := c.targets[target]; ok {
+		for endpoint, state := range t.endpoints {
+			states[endpoint] = state
+		}
+	}
+	return states
+}
+
+// decoder selects the decoder of the endpoint for the firmware of the
+// target. Targets whose firmware has not been negotiated yet use the newest
+// decoder.
+func (c *CompatibilityTracker) decoder(target string, endpoint string) (apiDecoder, bool, error) {
+	decoders := apiDecoders[endpoint]
+	if len(decoders) == 0 {
+		return apiDecoder{}, false, fmt.Errorf("no decoder for endpoint %q", endpoint)
+	}
+	newest := decoders[len(decoders)-1]
+
+	if versionEndpoints[endpoint] {
+		return newest, false, nil
+	}
+
+	// Negotiate may change the version concurrently, so it is copied under
+	// the lock.
+	var version string
+	c.mtx.Lock()
+	if t, ok := c.targets[target]; ok {
+		version = t.firmwareVersion
+	}
+	c.mtx.Unlock()
+	if version == "" {
+		return newest, false, nil
+	}
+
+	if CompareFirmwareVersions(version, decoders[0].minVersion) < 0 {
+		return apiDecoder{}, false, ErrUnsupported
+	}
+	if majorVersion(version) > majorVersion(newest.minVersion) {
+		return newest, true, nil
+	}
+	selected := decoders[0]
+	for _, d := range decoders {
+		if CompareFirmwareVersions(version, d.minVersion) >= 0 {
+			selected = d
+		}
+	}
+	return selected, false, nil
+}
+
+// record stores the compatibility state of an endpoint, logging whenever an
+// endpoint changes state so firmware incompatibilities are easy to spot.
+func (c *CompatibilityTracker) record(target string, endpoint string, state string, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	t, ok := c.targets[target]
+	if !ok {
+		t = &targetCompatibility{endpoints: map[string]string{}}
+		c.targets[target] = t
+	}
+	previous, seen := t.endpoints[endpoint]
+	t.endpoints[endpoint] = state
+	if previous == state {
+		return
+	}
+	switch state {
+	case CompatibilityOK:
+		if seen {
+			level.Info(c.logger).Log("msg", "Endpoint compatible again", "target", target, "endpoint", endpoint, "firmware_version", t.firmwareVersion)
+		}
+	case CompatibilityError:
+		// Transport errors say nothing about compatibility and are logged
+		// by the collectors.
+	default:
+		level.Warn(c.logger).Log("msg", "Endpoint incompatible with firmware, its metrics are skipped", "target", target, "endpoint", endpoint, "state", state, "firmware_version", t.firmwareVersion, "err", err)
+	}
+}
+
+// fetch requests an endpoint of the target and decodes the response into v
+// with the decoder selected for the firmware of the target.
+func fetch(target string, user string, password string, method string, endpoint string, path string, v interface{}) error {
+	d, untested, err := Compatibility.decoder(target, endpoint)
+	if err != nil {
+		Compatibility.record(target, endpoint, CompatibilityUnsupported, err)
+		return err
+	}
+	response, err := doRequest(clientFor(target), target+path, user, password, method)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound && resourceEndpoints[endpoint] {
+			return err
+		}
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusNotImplemented) {
+			Compatibility.record(target, endpoint, CompatibilityUnsupported, err)
+		} else {
+			Compatibility.record(target, endpoint, CompatibilityError, err)
+		}
+		return err
+	}
+	if err := d.decode(response, v); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			Compatibility.record(target, endpoint, CompatibilityError, err)
+		} else {
+			Compatibility.record(target, endpoint, CompatibilityDecodeError, err)
+		}
+		return err
+	}
+	if untested {
+		Compatibility.record(target, endpoint, CompatibilityUntested, nil)
+	} else {
+		Compatibility.record(target, endpoint, CompatibilityOK, nil)
+	}
+	return nil
+}
+
+func majorVersion(version string) int {
+	parts := versionParts(version)
+	if len(parts) == 0 {
+		return 0
+	}
+	return parts[0]
+}
@@ -0,0 +1,102 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestDecoderSelection(t *testing.T) {
+	tests := []struct {
+		version     string
+		endpoint    string
+		unsupported bool
+		untested    bool
+		minVersion  string
+	}{
+		{"", EndpointSystemStatus, false, false, "4.14"},
+		{"3.20.0", EndpointSystemStatus, true, false, ""},
+		{"4.2.0", EndpointSystemStatus, false, false, "4.0"},
+		{"4.14.2", EndpointSystemStatus, false, false, "4.14"},
+		{"5.0.1", EndpointSystemStatus, false, true, "4.14"},
+		// The version endpoints are requested whatever the firmware.
+		{"3.20.0", EndpointFirmwareVersion, false, false, ""},
+		{"3.20.0", EndpointDeviceInfo, false, false, ""},
+	}
+	for _, test := range tests {
+		c := NewCompatibilityTracker(log.NewNopLogger())
+		if test.version != "" {
+			c.Negotiate("pearl.local", test.version)
+		}
+		d, untested, err := c.decoder("pearl.local", test.endpoint)
+		if test.unsupported {
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("%s on %q: got error %v, want unsupported", test.endpoint, test.version, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s on %q: %s", test.endpoint, test.version, err)
+			continue
+		}
+		if untested != test.untested {
+			t.Errorf("%s on %q: untested %t, want %t", test.endpoint, test.version, untested, test.untested)
+		}
+		if test.minVersion != "" && d.minVersion != test.minVersion {
+			t.Errorf("%s on %q: selected decoder for %s, want %s", test.endpoint, test.version, d.minVersion, test.minVersion)
+		}
+	}
+}
+
+// TestNegotiateConcurrently selects decoders while concurrent probes of the
+// same target negotiate its firmware version. As in a probe, the version
+// request is recorded before the version is negotiated. Run with -race.
+func TestNegotiateConcurrently(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		c := NewCompatibilityTracker(log.NewNopLogger())
+		c.record("pearl.local", EndpointFirmwareVersion, CompatibilityOK, nil)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				<-start
+				if _, _, err := c.decoder("pearl.local", EndpointSystemStatus); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				c.Negotiate("pearl.local", "4.2.0")
+			}()
+		}
+		close(start)
+		wg.Wait()
+	}
+}
@@ -27,17 +27,60 @@ type FirmwareVersion struct {
 	Result string
 }
 
+type DeviceInfo struct {
+	Status string
+	Result DeviceInfoDetails
+}
+
+type DeviceInfoDetails struct {
+	Product  string
+	Serial   string
+	Hostname string
+}
+
 type SystemStatus struct {
 	Status string
 	Result SystemStatusDetails
 }
 
+// SystemStatusDetails holds the system status. The CPU fields are nil when
+// the device leaves them out.
 type SystemStatusDetails struct {
 	Date        string
 	Uptime      int64
-	CpuLoad     int64
-	CpuLoadHigh bool
-	Cputemp     int64
+	CpuLoad     *int64
+	CpuLoadHigh *bool
+	Cputemp     *int64
+}
+
+type Sensors struct {
+	Status string
+	Result []SensorDetails
+}
+
+// SensorDetails holds a reading of a hardware sensor. Type is "temperature",
+// in degrees Celsius, or "fan", in RPM.
+type SensorDetails struct {
+	Id    string
+	Name  string
+	Type  string
+	Value float64
+}
+
+type DateTime struct {
+	Status string
+	Result DateTimeDetails
+}
+
+type DateTimeDetails struct {
+	Timezone string
+	Ntp      NtpDetails
+}
+
+type NtpDetails struct {
+	Enabled      *bool
+	Server       string
+	Synchronized *bool
 }
 
 type FirmwareControl struct {
@@ -59,11 +102,50 @@ type StorageStatus struct {
 }
 
 type StorageStatusDetails struct {
+	State string
+	Total *int64
+	Free  *int64
+}
+
+type StoragesStatus struct {
+	Status string
+	Result []StoragesStatusDetails
+}
+
+type StoragesStatusDetails struct {
+	Id    string
+	Name  string
 	State string
 	Total int64
 	Free  int64
 }
 
+type NetworkStatus struct {
+	Status string
+	Result []NetworkInterfaceDetails
+}
+
+// NetworkInterfaceDetails holds the state of a network interface. Speed is in
+// Mbit/s. Counters are only reported by some firmware versions.
+type NetworkInterfaceDetails struct {
+	Id       string
+	Link     bool
+	Speed    *int64
+	Duplex   string
+	Dhcp     bool
+	Ipv4     NetworkAddressDetails
+	RxBytes  *int64 `json:"rx_bytes"`
+	TxBytes  *int64 `json:"tx_bytes"`
+	RxErrors *int64 `json:"rx_errors"`
+	TxErrors *int64 `json:"tx_errors"`
+}
+
+type NetworkAddressDetails struct {
+	Address string
+	Netmask string
+	Gateway string
+}
+
 type RecorderStatusDetails struct {
 	Id     string
 	Status RecorderStatusDetailsRecorderDetails
@@ -81,6 +163,41 @@ type RecorderStatus struct {
 	Result []RecorderStatusDetails
 }
 
+// ControlResponse is the answer to a control request, such as starting a
+// recorder.
+type ControlResponse struct {
+	Status string
+}
+
+type CmsStatus struct {
+	Status string
+	Result CmsStatusDetails
+}
+
+// CmsStatusDetails holds the state of the integration with a content
+// management system such as Kaltura, Panopto or Opencast.
+type CmsStatusDetails struct {
+	Enabled  bool
+	Type     string
+	State    string
+	LastSync string `json:"last_sync"`
+}
+
+type CmsEvents struct {
+	Status string
+	Result []CmsEventDetails
+}
+
+// CmsEventDetails holds a recording scheduled by the CMS. Recorder is the id
+// of the recorder the event records with, if the CMS assigned one.
+type CmsEventDetails struct {
+	Id       string
+	Title    string
+	Start    string
+	End      string
+	Recorder string
+}
+
 type ChannelStatus struct {
 	Status string
 	Result []ChannelStatusDetails
@@ -94,9 +211,9 @@ type ChannelStatusDetails struct {
 
 type ChannelStatusDetailsStatus struct {
 	State    string
-	Nosignal float64
-	Bitrate  float64
-	Duration float64
+	Nosignal *float64
+	Bitrate  *float64
+	Duration *float64
 }
 
 type ChannelStatusDetailsPublishers struct {
@@ -111,45 +228,50 @@ type ChannelStatusDetailsPublishersDetails struct {
 	Duration     int64
 }
 
-type SDIStatus struct {
+type ChannelEncoding struct {
 	Status string
-	Result []SDIStatusDetails
+	Result ChannelEncodingDetails
 }
 
-type SDIStatusDetails struct {
-	Id     string
-	Name   string
-	Status SDIConnectionStatus
+// ChannelEncodingDetails holds the configured encoding of a channel. Bitrate
+// is the target bitrate in kbit/s.
+type ChannelEncodingDetails struct {
+	Codec      string
+	Resolution string
+	Framerate  float64
+	Bitrate    int64
 }
 
-type SDIConnectionStatus struct {
-	Video SDIVideoConnectionStatus
+type ChannelLayouts struct {
+	Status string
+	Result []ChannelLayoutDetails
 }
 
-type SDIVideoConnectionStatus struct {
-	Actual_fps int
-	Interlaced bool
-	Resolution string
-	State      string
-	Vrr        int
+type ChannelLayoutDetails struct {
+	Id     string
+	Name   string
+	Active bool
 }
 
-type HDMIStatus struct {
+// SourceStatus holds the status of any kind of source. Video and Audio are
+// only set for sources carrying that kind of signal.
+type SourceStatus struct {
 	Status string
-	Result []HDMIStatusDetails
+	Result []SourceStatusDetails
 }
 
-type HDMIStatusDetails struct {
+type SourceStatusDetails struct {
 	Id     string
 	Name   string
-	Status HDMIConnectionStatus
+	Status SourceConnectionStatus
 }
 
-type HDMIConnectionStatus struct {
-	Video HDMIVideoConnectionStatus
+type SourceConnectionStatus struct {
+	Video *VideoConnectionStatus
+	Audio *AudioConnectionStatus
 }
 
-type HDMIVideoConnectionStatus struct {
+type VideoConnectionStatus struct {
 	Actual_fps int
 	Interlaced bool
 	Resolution string
@@ -157,12 +279,31 @@ type HDMIVideoConnectionStatus struct {
 	Vrr        int
 }
 
-type RCAVolumeStatus struct {
+type AudioConnectionStatus struct {
+	State    string
+	Channels int
+}
+
+type SourceList struct {
+	Status string
+	Result []SourceListDetails
+}
+
+type SourceListDetails struct {
+	Id    string
+	Name  string
+	Video bool
+	Audio bool
+}
+
+// AudioLevels holds the current peak and RMS level in dBFS for every channel
+// of an audio source. Mono sources report a single channel.
+type AudioLevels struct {
 	Status string
-	Result RCAVolumeDetails
+	Result AudioLevelsDetails
 }
 
-type RCAVolumeDetails struct {
+type AudioLevelsDetails struct {
 	Peak []float64
 	Rms  []float64
 }
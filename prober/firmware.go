@@ -0,0 +1,198 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// FirmwareUpdateCheck is the cached outcome of a firmware update check.
+type FirmwareUpdateCheck struct {
+	Control   *FirmwareControl
+	Err       error
+	Timestamp time.Time
+}
+
+// FirmwareUpdateChecker caches firmware update checks per target. Checking
+// for updates is a POST that makes the device contact the vendor, so it is
+// only repeated once the previous result is older than the check interval.
+type FirmwareUpdateChecker struct {
+	mtx     sync.Mutex
+	targets map[string]*firmwareUpdateTarget
+}
+
+type firmwareUpdateTarget struct {
+	mtx   sync.Mutex
+	check *FirmwareUpdateCheck
+}
+
+func NewFirmwareUpdateChecker() *FirmwareUpdateChecker {
+	return &FirmwareUpdateChecker{
+		targets: map[string]*firmwareUpdateTarget{},
+	}
+}
+
+// Check returns the cached update check for the target, running a new check
+// when there is none or it is older than interval. Failed checks are cached
+// as well so an unreachable update server is not retried on every scrape.
+func (c *FirmwareUpdateChecker) Check(target string, user string, password string, interval time.Duration) *FirmwareUpdateCheck {
+	c.mtx.Lock()
+	t, ok := c.targets[target]
+	if !ok {
+		t = &firmwareUpdateTarget{}
+		c.targets[target] = t
+	}
+	c.mtx.Unlock()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.check != nil && time.Since(t.check.Timestamp) < interval {
+		return t.check
+	}
+	control, err := GetFirmwareUpdateAvailability(target, user, password)
+	t.check = &FirmwareUpdateCheck{
+		Control:   control,
+		Err:       err,
+		Timestamp: time.Now(),
+	}
+	return t.check
+}
+
+// Cached returns the last update check of the target without running one,
+// or nil if the target was not checked yet.
+func (c *FirmwareUpdateChecker) Cached(target string) *FirmwareUpdateCheck {
+	c.mtx.Lock()
+	t, ok := c.targets[target]
+	c.mtx.Unlock()
+	if !ok {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.check
+}
+
+// Status returns the update status reported by the device, or "unknown" when
+// the check failed.
+func (c *FirmwareUpdateCheck) Status() string {
+	if c.Err != nil || c.Control == nil {
+		return "unknown"
+	}
+	return c.Control.Result.Status
+}
+
+// AvailableVersion returns the firmware version offered by the update
+// server, if any.
+func (c *FirmwareUpdateCheck) AvailableVersion() string {
+	if c.Err != nil || c.Control == nil || c.Control.Result.Version == nil {
+		return ""
+	}
+	return *c.Control.Result.Version
+}
+
+// CompareFirmwareVersions compares two firmware versions such as "4.14.2" or
+// "4.15.0 build 5123" numerically component by component. Missing components
+// count as 0, so "4.14" equals "4.14.0". A pre-release such as "4.15.0-rc1"
+// is older than the release it precedes. It returns -1, 0 or 1 when a is
+// older than, equal to or newer than b.
+func CompareFirmwareVersions(a string, b string) int {
+	ra, pa := splitPreRelease(a)
+	rb, pb := splitPreRelease(b)
+	if c := compareParts(versionParts(ra), versionParts(rb)); c != 0 {
+		return c
+	}
+	switch {
+	case pa == "" && pb == "":
+		return 0
+	case pa == "":
+		return 1
+	case pb == "":
+		return -1
+	}
+	if c := compareInts(preReleaseRanks[preReleaseTag.FindStringSubmatch(pa)[1]], preReleaseRanks[preReleaseTag.FindStringSubmatch(pb)[1]]); c != 0 {
+		return c
+	}
+	return compareParts(versionParts(pa), versionParts(pb))
+}
+
+// preReleaseTag matches the start of the pre-release part of a version, a
+// word such as "rc" or "beta2".
+var preReleaseTag = regexp.MustCompile(`(?i)(?:^|[^a-z])(dev|alpha|beta|pre|rc)(?:[^a-z]|$)`)
+
+var preReleaseRanks = map[string]int{"dev": 1, "alpha": 2, "beta": 3, "pre": 4, "rc": 5}
+
+// splitPreRelease splits a version into its release and its pre-release
+// part, which is empty for releases.
+func splitPreRelease(version string) (string, string) {
+	loc := preReleaseTag.FindStringSubmatchIndex(version)
+	if loc == nil {
+		return version, ""
+	}
+	return version[:loc[2]], strings.ToLower(version[loc[2]:])
+}
+
+func compareParts(pa []int, pb []int) int {
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var va, vb int
+		if i < len(pa) {
+			va = pa[i]
+		}
+		if i < len(pb) {
+			vb = pb[i]
+		}
+		if c := compareInts(va, vb); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func versionParts(version string) []int {
+	fields := strings.FieldsFunc(version, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+	parts := make([]int, 0, len(fields))
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
@@ -0,0 +1,65 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import "testing"
+
+func TestCompareFirmwareVersions(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"4.14.2", "4.14.2", 0},
+		{"4.14.2", "4.14.10", -1},
+		{"4.15.0", "4.14.10", 1},
+		{"5.0", "4.99.99", 1},
+		// Missing components count as 0.
+		{"4.14", "4.14.0", 0},
+		{"4", "4.0.0", 0},
+		{"4.14", "4.14.1", -1},
+		{"", "0", 0},
+		{"", "4.14", -1},
+		{"v4.14.2", "4.14.2", 0},
+		// Build numbers are compared after the version.
+		{"4.15.0 build 5123", "4.15.0 build 5124", -1},
+		{"4.15.0 build 5123", "4.15.0", 1},
+		// Pre-releases precede their release, in order.
+		{"4.15.0-rc1", "4.15.0", -1},
+		{"4.15.0", "4.15.0-rc1", 1},
+		{"4.15.0-rc1", "4.14.2", 1},
+		{"4.15.0-beta2", "4.15.0-rc1", -1},
+		{"4.15.0-rc2", "4.15.0-rc10", -1},
+		{"4.15.0-RC1", "4.15.0-rc1", 0},
+		{"4.15.0beta", "4.15.0 beta", 0},
+		{"4.15.0-alpha", "4.15.0-beta", -1},
+		{"4.15-rc1", "4.15.0", -1},
+		// Words merely containing a tag are not pre-releases.
+		{"4.15.0 source", "4.15.0", 0},
+	}
+	for _, test := range tests {
+		if got := CompareFirmwareVersions(test.a, test.b); got != test.want {
+			t.Errorf("CompareFirmwareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"strings"
+	"sync"
+)
+
+// ModelProfile describes what a device model supports, so only endpoints
+// and sources that exist on the model are probed.
+type ModelProfile struct {
+	Name string
+	// Collectors lists the names of the collectors supported by the model.
+	Collectors []string
+	// Sources lists the inputs of the model. When empty the sources are
+	// read from the device.
+	Sources []SourceListDetails
+	// match is matched case-insensitively against the product name.
+	match []string
+}
+
+var (
+	pearlCollectors = []string{"system", "thermal", "clock", "firmware", "network", "storage", "channels", "channel_config", "channel_preview", "recorders", "cms", "schedule", "sources", "audio"}
+
+	// GenericProfile is used for devices of an unknown model. It tries every
+	// collector common to the Pearl family and discovers the sources.
+	GenericProfile = ModelProfile{
+		Name:       "unknown",
+		Collectors: pearlCollectors,
+	}
+
+	ModelProfiles = []ModelProfile{
+		{
+			Name:       "Pearl Mini",
+			Collectors: pearlCollectors,
+			Sources: []SourceListDetails{
+				{Id: "D2P0.hdmi-a", Name: "HDMI-A", Video: true, Audio: true},
+				{Id: "D2P0.hdmi-b", Name: "HDMI-B", Video: true, Audio: true},
+				{Id: "D2P0.sdi", Name: "SDI", Video: true, Audio: true},
+				{Id: "D2P0.usb", Name: "USB", Video: true, Audio: true},
+				{Id: "D2P0.analog-a", Name: "XLR", Audio: true},
+				{Id: "D2P0.analog-b", Name: "RCA", Audio: true},
+			},
+			match: []string{"pearl mini", "pearl-mini"},
+		},
+		{
+			Name:       "Pearl-2",
+			Collectors: pearlCollectors,
+			Sources: []SourceListDetails{
+				{Id: "D2P280.hdmi-a", Name: "HDMI-A", Video: true, Audio: true},
+				{Id: "D2P280.hdmi-b", Name: "HDMI-B", Video: true, Audio: true},
+				{Id: "D2P280.sdi-a", Name: "SDI-A", Video: true, Audio: true},
+				{Id: "D2P280.sdi-b", Name: "SDI-B", Video: true, Audio: true},
+				{Id: "D2P280.usb", Name: "USB", Video: true, Audio: true},
+				{Id: "D2P280.analog-a", Name: "XLR-A", Audio: true},
+				{Id: "D2P280.analog-b", Name: "XLR-B", Audio: true},
+				{Id: "D2P280.analog-c", Name: "RCA", Audio: true},
+			},
+			match: []string{"pearl-2", "pearl 2", "pearl2"},
+		},
+		{
+			// The Nexus has several storage volumes, collected by "storages".
+			Name:       "Pearl Nexus",
+			Collectors: append([]string{"storages"}, pearlCollectors...),
+			Sources: []SourceListDetails{
+				{Id: "D2P0.hdmi-a", Name: "HDMI-A", Video: true, Audio: true},
+				{Id: "D2P0.hdmi-b", Name: "HDMI-B", Video: true, Audio: true},
+				{Id: "D2P0.sdi", Name: "SDI", Video: true, Audio: true},
+				{Id: "D2P0.analog-a", Name: "XLR", Audio: true},
+			},
+			match: []string{"pearl nexus", "pearl-nexus"},
+		},
+		{
+			// The EC20 is a camera: it streams but has no recorders or storage.
+			Name:       "EC20",
+			Collectors: []string{"system", "thermal", "clock", "firmware", "network", "channels", "channel_config", "channel_preview", "sources", "audio"},
+			Sources: []SourceListDetails{
+				{Id: "EC20.camera", Name: "Camera", Video: true},
+				{Id: "EC20.mic", Name: "Microphone", Audio: true},
+			},
+			match: []string{"ec20", "ec-20"},
+		},
+	}
+)
+
+// ProfileForProduct returns the profile of the model with the given product
+// name, or the generic profile if the model is not known.
+func ProfileForProduct(product string) *ModelProfile {
+	product = strings.ToLower(product)
+	for i, profile := range ModelProfiles {
+		for _, match := range profile.match {
+			if strings.Contains(product, match) {
+				return &ModelProfiles[i]
+			}
+		}
+	}
+	return &GenericProfile
+}
+
+// HasCollector reports whether the model supports the named collector.
+func (p *ModelProfile) HasCollector(name string) bool {
+	for _, collector := range p.Collectors {
+		if collector == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceIds returns the ids of the known sources of the model.
+func (p *ModelProfile) SourceIds() []string {
+	ids := make([]string, 0, len(p.Sources))
+	for _, source := range p.Sources {
+		ids = append(ids, source.Id)
+	}
+	return ids
+}
+
+// ModelDetector remembers the model of every target after first contact.
+type ModelDetector struct {
+	mtx     sync.Mutex
+	devices map[string]*DeviceInfo
+}
+
+func NewModelDetector() *ModelDetector {
+	return &ModelDetector{
+		devices: map[string]*DeviceInfo{},
+	}
+}
+
+// Detect returns the device info and model profile of the target, reading the
+// device info only if the target has not been seen before.
+func (d *ModelDetector) Detect(target string, user string, password string) (*DeviceInfo, *ModelProfile, error) {
+	d.mtx.Lock()
+	device, ok := d.devices[target]
+	d.mtx.Unlock()
+	if ok {
+		return device, ProfileForProduct(device.Result.Product), nil
+	}
+
+	device, err := GetDeviceInfo(target, user, password)
+	if err != nil {
+		return nil, &GenericProfile, err
+	}
+	d.mtx.Lock()
+	d.devices[target] = device
+	d.mtx.Unlock()
+	return device, ProfileForProduct(device.Result.Product), nil
+}
+
+// Forget drops the remembered model of the target, so it is detected again on
+// the next contact, e.g. after the device has been replaced.
+func (d *ModelDetector) Forget(target string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.devices, target)
+}
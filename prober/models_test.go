@@ -0,0 +1,99 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import "testing"
+
+func TestProfileForProduct(t *testing.T) {
+	tests := []struct {
+		product string
+		want    string
+	}{
+		{"Pearl Mini", "Pearl Mini"},
+		{"PEARL-MINI", "Pearl Mini"},
+		{"Epiphan Pearl Mini", "Pearl Mini"},
+		{"Pearl-2", "Pearl-2"},
+		{"Pearl 2 Rackmount", "Pearl-2"},
+		{"pearl2", "Pearl-2"},
+		{"Pearl Nexus", "Pearl Nexus"},
+		{"EC20", "EC20"},
+		{"EC-20 PTZ", "EC20"},
+		{"Pearl", "unknown"},
+		{"LiveScrypt", "unknown"},
+		{"", "unknown"},
+	}
+	for _, test := range tests {
+		if got := ProfileForProduct(test.product).Name; got != test.want {
+			t.Errorf("ProfileForProduct(%q) = %s, want %s", test.product, got, test.want)
+		}
+	}
+}
+
+func TestModelProfileCollectors(t *testing.T) {
+	tests := []struct {
+		product   string
+		collector string
+		want      bool
+	}{
+		{"Pearl Mini", "recorders", true},
+		{"Pearl Mini", "storages", false},
+		{"Pearl Nexus", "storages", true},
+		{"Pearl Nexus", "storage", true},
+		{"EC20", "recorders", false},
+		{"EC20", "storage", false},
+		{"EC20", "audio", true},
+		{"unknown", "recorders", true},
+		{"unknown", "storages", false},
+		{"Pearl Mini", "", false},
+	}
+	for _, test := range tests {
+		if got := ProfileForProduct(test.product).HasCollector(test.collector); got != test.want {
+			t.Errorf("%s has collector %q = %t, want %t", test.product, test.collector, got, test.want)
+		}
+	}
+
+	// Adding a collector to the Nexus must not change the shared list of
+	// the other models.
+	if len(ModelProfiles[0].Collectors) != len(pearlCollectors) {
+		t.Errorf("Pearl Mini has %d collectors, want %d", len(ModelProfiles[0].Collectors), len(pearlCollectors))
+	}
+}
+
+func TestModelProfileSourceIds(t *testing.T) {
+	if ids := GenericProfile.SourceIds(); len(ids) != 0 {
+		t.Errorf("the generic profile has sources %v, want none so they are discovered", ids)
+	}
+	for _, profile := range ModelProfiles {
+		ids := profile.SourceIds()
+		if len(ids) != len(profile.Sources) {
+			t.Errorf("%s: got %d source ids for %d sources", profile.Name, len(ids), len(profile.Sources))
+		}
+		seen := map[string]bool{}
+		for _, id := range ids {
+			if id == "" || seen[id] {
+				t.Errorf("%s: empty or duplicate source id %q", profile.Name, id)
+			}
+			seen[id] = true
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+func GetFirmwareVersion(target string, user string, password string) (*FirmwareVersion, error) {
+	f := FirmwareVersion{}
+	err := fetch(target, user, password, "GET", EndpointFirmwareVersion, "/api/system/firmware/version", &f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func GetDeviceInfo(target string, user string, password string) (*DeviceInfo, error) {
+	d := DeviceInfo{}
+	err := fetch(target, user, password, "GET", EndpointDeviceInfo, "/api/system/info", &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func GetFirmwareUpdateAvailability(target string, user string, password string) (*FirmwareControl, error) {
+	f := FirmwareControl{}
+	err := fetch(target, user, password, "POST", EndpointFirmwareUpdate, "/api/system/firmware/update/control/check", &f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func GetStorageInfo(target string, user string, password string) (*StorageStatus, error) {
+	s := StorageStatus{}
+	err := fetch(target, user, password, "GET", EndpointStorage, "/api/system/storages/main/status", &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetStoragesInfo(target string, user string, password string) (*StoragesStatus, error) {
+	s := StoragesStatus{}
+	err := fetch(target, user, password, "GET", EndpointStorages, "/api/system/storages/status", &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetSystemInfo(target string, user string, password string) (*SystemStatus, error) {
+	s := SystemStatus{}
+	err := fetch(target, user, password, "GET", EndpointSystemStatus, "/api/system/status", &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetSensors(target string, user string, password string) (*Sensors, error) {
+	s := Sensors{}
+	err := fetch(target, user, password, "GET", EndpointSensors, "/api/system/sensors", &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetDateTime(target string, user string, password string) (*DateTime, error) {
+	d := DateTime{}
+	err := fetch(target, user, password, "GET", EndpointDateTime, "/api/system/datetime", &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func GetNetworkInfo(target string, user string, password string) (*NetworkStatus, error) {
+	n := NetworkStatus{}
+	err := fetch(target, user, password, "GET", EndpointNetwork, "/api/system/network/status", &n)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ControlRecorder starts or stops a recorder. Action is "start" or "stop".
+func ControlRecorder(target string, user string, password string, id string, action string) error {
+	if action != "start" && action != "stop" {
+		return fmt.Errorf("unknown recorder action %q", action)
+	}
+	r := ControlResponse{}
+	return fetch(target, user, password, "POST", EndpointRecorderControl, "/api/recorders/"+url.PathEscape(id)+"/control/"+action, &r)
+}
+
+// ControlPublisher starts or stops a publisher of a channel. Action is
+// "start" or "stop".
+func ControlPublisher(target string, user string, password string, channel string, id string, action string) error {
+	if action != "start" && action != "stop" {
+		return fmt.Errorf("unknown publisher action %q", action)
+	}
+	r := ControlResponse{}
+	return fetch(target, user, password, "POST", EndpointPublisherControl, "/api/channels/"+url.PathEscape(channel)+"/publishers/"+url.PathEscape(id)+"/control/"+action, &r)
+}
+
+// Reboot restarts the device.
+func Reboot(target string, user string, password string) error {
+	r := ControlResponse{}
+	return fetch(target, user, password, "POST", EndpointReboot, "/api/system/control/reboot", &r)
+}
+
+func GetCmsStatus(target string, user string, password string) (*CmsStatus, error) {
+	c := CmsStatus{}
+	err := fetch(target, user, password, "GET", EndpointCmsStatus, "/api/cms/status", &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func GetCmsEvents(target string, user string, password string) (*CmsEvents, error) {
+	e := CmsEvents{}
+	err := fetch(target, user, password, "GET", EndpointCmsEvents, "/api/cms/events", &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func GetRecorderInfo(target string, user string, password string) (*RecorderStatus, error) {
+	r := RecorderStatus{}
+	err := fetch(target, user, password, "GET", EndpointRecorders, "/api/recorders/status", &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func GetChannelInfo(target string, user string, password string) (*ChannelStatus, error) {
+	c := ChannelStatus{}
+	err := fetch(target, user, password, "GET", EndpointChannels, "/api/channels/status?publishers=true", &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func GetChannelEncoding(target string, user string, password string, channel string) (*ChannelEncoding, error) {
+	e := ChannelEncoding{}
+	err := fetch(target, user, password, "GET", EndpointChannelEncoding, "/api/channels/"+url.PathEscape(channel)+"/encoding", &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func GetChannelLayouts(target string, user string, password string, channel string) (*ChannelLayouts, error) {
+	l := ChannelLayouts{}
+	err := fetch(target, user, password, "GET", EndpointChannelLayouts, "/api/channels/"+url.PathEscape(channel)+"/layouts", &l)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// GetSourceStatus returns the status of the given sources, or of every source
+// when no ids are given. Sources unknown to the device are left out of the
+// result.
+func GetSourceStatus(target string, user string, password string, ids []string) (*SourceStatus, error) {
+	s := SourceStatus{}
+	path := "/api/sources/status"
+	if len(ids) > 0 {
+		path = path + "?ids=" + url.QueryEscape(strings.Join(ids, ","))
+	}
+	err := fetch(target, user, password, "GET", EndpointSourceStatus, path, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetSources(target string, user string, password string) (*SourceList, error) {
+	s := SourceList{}
+	err := fetch(target, user, password, "GET", EndpointSources, "/api/sources", &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetAudioLevels(target string, user string, password string, source string) (*AudioLevels, error) {
+	a := AudioLevels{}
+	err := fetch(target, user, password, "GET", EndpointAudioLevels, "/api/sources/"+url.PathEscape(source)+"/audiolevels", &a)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// TLSConfig returns the TLS settings for requests to a target. Nil selects
+// the default of not verifying certificates, as devices ship with
+// self-signed ones.
+var TLSConfig = func(target string) *tls.Config { return nil }
+
+// AddressAllowed reports whether connections to the address the given
+// target host resolved to are allowed. It is checked for the address
+// actually dialled, so a DNS answer changing after the target was checked
+// cannot lead to another host.
+var AddressAllowed = func(host string, ip net.IP) bool { return true }
+
+var (
+	// client is shared by all requests without TLS settings, which may run
+	// concurrently from the probe handler and the sampler.
+	client = newClient(insecureTLSConfig)
+
+	insecureTLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+	clientsMtx sync.Mutex
+	clients    = map[*tls.Config]*http.Client{}
+)
+
+func newClient(tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = dialContext
+	return &http.Client{
+		Transport:     wrapTransport(transport),
+		Timeout:       10 * time.Second,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// checkRedirect only follows redirects to the host and port of the original
+// request that do not downgrade from https to http, so a device cannot send
+// probes and their credentials to a host that is not an allowed target or in
+// cleartext. Credentials are only sent again with the scheme and host of the
+// original request.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	original := via[0].URL
+	if req.URL.Hostname() != original.Hostname() {
+		return fmt.Errorf("refusing redirect to other host %q", req.URL.Host)
+	}
+	if req.URL.Port() != original.Port() {
+		return fmt.Errorf("refusing redirect to other port %q", req.URL.Host)
+	}
+	if original.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect from https to %s", req.URL.Scheme)
+	}
+	if user, password, ok := via[0].BasicAuth(); ok && req.URL.Scheme == original.Scheme && req.URL.Host == original.Host {
+		req.SetBasicAuth(user, password)
+	}
+	return nil
+}
+
+// dialContext connects to addr, refusing addresses that AddressAllowed
+// rejects for its host.
+func dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			ipStr, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(ipStr); ip == nil || !AddressAllowed(host, ip) {
+				return fmt.Errorf("refusing to connect to %s, it is not an allowed target address for %s", ipStr, host)
+			}
+			return nil
+		},
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// clientFor returns the HTTP client for the TLS settings of the target.
+func clientFor(target string) *http.Client {
+	tlsConfig := TLSConfig(target)
+	if tlsConfig == nil {
+		return client
+	}
+	clientsMtx.Lock()
+	defer clientsMtx.Unlock()
+	c, ok := clients[tlsConfig]
+	if !ok {
+		c = newClient(tlsConfig)
+		clients[tlsConfig] = c
+	}
+	return c
+}
+
+// ResetClients closes the connections of the clients created for TLS
+// settings that may no longer be in use, e.g. after a config reload.
+func ResetClients() {
+	clientsMtx.Lock()
+	defer clientsMtx.Unlock()
+	for _, c := range clients {
+		c.CloseIdleConnections()
+	}
+	clients = map[*tls.Config]*http.Client{}
+}
+
+func doRequest(client *http.Client, target string, user string, password string, method string) ([]byte, error) {
+	logger := log.NewLogfmtLogger(os.Stdout)
+	logger = level.NewFilter(logger, level.AllowInfo())
+	logger = log.With(logger, "caller", log.DefaultCaller)
+
+	level.Debug(logger).Log("msg", "Probing url : "+target)
+
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(user, password)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body := struct{ Message string }{}
+		json.Unmarshal(bodyBytes, &body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: body.Message}
+	}
+	return bodyBytes, nil
+}
+
+// Recording reports whether the recorder is not stopped.
+func (r RecorderStatusDetails) Recording() bool {
+	return r.Status.State != "stopped"
+}
+
+// Recorder returns the status of the recorder with the given id.
+func (r *RecorderStatus) Recorder(id string) (*RecorderStatusDetails, bool) {
+	for i := range r.Result {
+		if r.Result[i].Id == id {
+			return &r.Result[i], true
+		}
+	}
+	return nil, false
+}
+
+// Recording reports whether any recorder is recording.
+func (r *RecorderStatus) Recording() bool {
+	for _, recorder := range r.Result {
+		if recorder.Recording() {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveLayout returns the name of the active layout, or "" if none is.
+func (l *ChannelLayouts) ActiveLayout() string {
+	for _, layout := range l.Result {
+		if layout.Active {
+			return layout.Name
+		}
+	}
+	return ""
+}
+
+// Source returns the status of the source with the given id.
+func (s *SourceStatus) Source(id string) (*SourceStatusDetails, bool) {
+	for i := range s.Result {
+		if s.Result[i].Id == id {
+			return &s.Result[i], true
+		}
+	}
+	return nil, false
+}
+
+// Silent reports whether the peak level of every channel is below the
+// threshold. Sources without any channels are never considered silent.
+func (a AudioLevelsDetails) Silent(thresholdDBFS float64) bool {
+	if len(a.Peak) == 0 {
+		return false
+	}
+	for _, peak := range a.Peak {
+		if peak >= thresholdDBFS {
+			return false
+		}
+	}
+	return true
+}
+
+func Bool2int(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoRequestRedirects(t *testing.T) {
+	var leaked bool
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, leaked = r.BasicAuth()
+		w.Write([]byte(`{"status":"ok","result":"4.14.2"}`))
+	}))
+	defer other.Close()
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/other":
+			// The other server listens on 127.0.0.1 as well, but is
+			// addressed by another host name.
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+		case "/ok":
+			if user, password, _ := r.BasicAuth(); user != "admin" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"status":"ok","result":"4.14.2"}`))
+		}
+	}))
+	defer device.Close()
+
+	if _, err := doRequest(client, device.URL+"/same", "admin", "secret", "GET"); err != nil {
+		t.Errorf("redirect to the same host failed: %s", err)
+	}
+	if _, err := doRequest(client, device.URL+"/other", "admin", "secret", "GET"); err == nil {
+		t.Error("redirect to another host was followed")
+	}
+	if leaked {
+		t.Error("credentials were sent to another host")
+	}
+}
+
+func TestDialChecksAddress(t *testing.T) {
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok","result":"4.14.2"}`))
+	}))
+	defer device.Close()
+	defer func(f func(string, net.IP) bool) { AddressAllowed = f }(AddressAllowed)
+
+	var checked []string
+	AddressAllowed = func(host string, ip net.IP) bool {
+		checked = append(checked, host+"="+ip.String())
+		return !ip.IsLoopback()
+	}
+	// The host name resolves to a loopback address, which is refused when
+	// it is dialled.
+	target := strings.Replace(device.URL, "127.0.0.1", "localhost", 1)
+	if _, err := doRequest(newClient(nil), target, "", "", "GET"); err == nil {
+		t.Error("connected to a refused address")
+	}
+	if len(checked) == 0 || !strings.HasPrefix(checked[0], "localhost=") {
+		t.Errorf("the dialled address was not checked for the host: %v", checked)
+	}
+
+	AddressAllowed = func(host string, ip net.IP) bool { return true }
+	if _, err := doRequest(newClient(nil), target, "", "", "GET"); err != nil {
+		t.Errorf("request to an allowed address failed: %s", err)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	tests := []struct {
+		from        string
+		to          string
+		allowed     bool
+		credentials bool
+	}{
+		{"https://pearl.local/api", "https://pearl.local/api/", true, true},
+		{"http://pearl.local/api", "http://pearl.local/login", true, true},
+		{"https://pearl.local:8443/api", "https://pearl.local:8443/api/", true, true},
+		// Upgrading is followed, but credentials are only sent again with
+		// the original scheme.
+		{"http://pearl.local/api", "https://pearl.local/api", true, false},
+		{"https://pearl.local/api", "http://pearl.local/api", false, false},
+		{"https://pearl.local/api", "https://pearl.local:8443/api", false, false},
+		{"http://pearl.local:8080/api", "http://pearl.local/api", false, false},
+		{"https://pearl.local/api", "https://evil.example.com/api", false, false},
+	}
+	for _, test := range tests {
+		original, _ := http.NewRequest("GET", test.from, nil)
+		original.SetBasicAuth("admin", "secret")
+		req, _ := http.NewRequest("GET", test.to, nil)
+		err := checkRedirect(req, []*http.Request{original})
+		if (err == nil) != test.allowed {
+			t.Errorf("redirect from %s to %s: got error %v, want allowed %t", test.from, test.to, err, test.allowed)
+		}
+		if _, _, ok := req.BasicAuth(); ok != test.credentials {
+			t.Errorf("redirect from %s to %s: credentials sent %t, want %t", test.from, test.to, ok, test.credentials)
+		}
+	}
+}
+
+func TestAudioLevelsSilent(t *testing.T) {
+	tests := []struct {
+		peak []float64
+		want bool
+	}{
+		{[]float64{-70, -65}, true},
+		{[]float64{-70}, true},
+		{[]float64{-70, -12}, false},
+		{[]float64{-12, -70}, false},
+		// A peak at the threshold is not silent.
+		{[]float64{-60, -70}, false},
+		{[]float64{-60.01, -70}, true},
+		// Sources without levels are not known to be silent.
+		{nil, false},
+		{[]float64{}, false},
+	}
+	for _, test := range tests {
+		levels := AudioLevelsDetails{Peak: test.peak, Rms: test.peak}
+		if got := levels.Silent(-60); got != test.want {
+			t.Errorf("Silent(-60) with peaks %v = %t, want %t", test.peak, got, test.want)
+		}
+	}
+}
+
+func TestGetSourceStatus(t *testing.T) {
+	var query string
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("ids")
+		w.Write([]byte(`{"status":"ok","result":[
+			{"id":"hdmi-a","name":"HDMI-A","status":{"video":{"state":"active","resolution":"1920x1080","actual_fps":30},"audio":{"state":"active","channels":2}}},
+			{"id":"sdi","name":"SDI","status":{"video":{"state":"no_signal","resolution":""}}},
+			{"id":"analog-a","name":"XLR","status":{"audio":{"state":"active","channels":1}}},
+			{"id":"usb","name":"USB","status":{}}
+		]}`))
+	}))
+	defer device.Close()
+
+	status, err := GetSourceStatus(device.URL, "", "", []string{"hdmi-a", "sdi", "analog-a", "usb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "hdmi-a,sdi,analog-a,usb" {
+		t.Errorf("requested ids %q", query)
+	}
+	tests := []struct {
+		id         string
+		video      string
+		audio      string
+		resolution string
+	}{
+		{"hdmi-a", "active", "active", "1920x1080"},
+		{"sdi", "no_signal", "", ""},
+		{"analog-a", "", "active", ""},
+		{"usb", "", "", ""},
+	}
+	if len(status.Result) != len(tests) {
+		t.Fatalf("got %d sources, want %d", len(status.Result), len(tests))
+	}
+	for i, test := range tests {
+		source := status.Result[i]
+		if source.Id != test.id {
+			t.Errorf("source %d is %q, want %q", i, source.Id, test.id)
+		}
+		if video := source.Status.Video; (video != nil) != (test.video != "") || video != nil && (video.State != test.video || video.Resolution != test.resolution) {
+			t.Errorf("video of %s = %+v, want state %q and resolution %q", test.id, video, test.video, test.resolution)
+		}
+		if audio := source.Status.Audio; (audio != nil) != (test.audio != "") || audio != nil && audio.State != test.audio {
+			t.Errorf("audio of %s = %+v, want state %q", test.id, audio, test.audio)
+		}
+	}
+}
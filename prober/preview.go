@@ -0,0 +1,153 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"image"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// previewGridWidth and previewGridHeight set the resolution previews are
+	// reduced to before comparing, which keeps encoder noise from being
+	// mistaken for motion.
+	previewGridWidth  = 32
+	previewGridHeight = 18
+)
+
+func GetChannelPreview(target string, user string, password string, channel string) (image.Image, error) {
+	var img image.Image
+	err := fetch(target, user, password, "GET", EndpointChannelPreview, "/api/channels/"+url.PathEscape(channel)+"/preview?format=jpg", &img)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// PreviewStats describes a channel preview compared to the previous one.
+type PreviewStats struct {
+	// Luminance is the average luminance of the preview between 0 and 1.
+	Luminance float64
+	// Black is set when the average luminance is below the black threshold.
+	Black bool
+	// FrozenSeconds is how long the preview has not changed noticeably, or 0
+	// if it changed since the previous preview.
+	FrozenSeconds float64
+}
+
+// PreviewAnalyzer keeps the last preview of every target and channel to
+// detect frozen pictures across scrapes.
+type PreviewAnalyzer struct {
+	mtx      sync.Mutex
+	previews map[string]*previewState
+}
+
+type previewState struct {
+	grid       []float64
+	lastChange time.Time
+}
+
+func NewPreviewAnalyzer() *PreviewAnalyzer {
+	return &PreviewAnalyzer{
+		previews: map[string]*previewState{},
+	}
+}
+
+// Analyze computes the statistics for a new preview of the channel. A
+// preview counts as unchanged when the mean luminance difference to the
+// previous one is below frozenDifference.
+func (p *PreviewAnalyzer) Analyze(target string, channel string, img image.Image, blackLuminance float64, frozenDifference float64) PreviewStats {
+	grid := luminanceGrid(img)
+	stats := PreviewStats{}
+	for _, l := range grid {
+		stats.Luminance += l
+	}
+	stats.Luminance /= float64(len(grid))
+	stats.Black = stats.Luminance < blackLuminance
+
+	now := time.Now()
+	key := target + "\x00" + channel
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	state, ok := p.previews[key]
+	if !ok || gridDifference(state.grid, grid) >= frozenDifference {
+		p.previews[key] = &previewState{grid: grid, lastChange: now}
+		return stats
+	}
+	state.grid = grid
+	stats.FrozenSeconds = now.Sub(state.lastChange).Seconds()
+	return stats
+}
+
+// luminanceGrid reduces the image to a fixed grid of average luminance
+// values between 0 and 1. Every cell averages the pixels it covers, or the
+// nearest pixel in images smaller than the grid.
+func luminanceGrid(img image.Image) []float64 {
+	bounds := img.Bounds()
+	grid := make([]float64, previewGridWidth*previewGridHeight)
+	if bounds.Empty() {
+		return grid
+	}
+	for gy := 0; gy < previewGridHeight; gy++ {
+		y0, y1 := cellPixels(gy, previewGridHeight, bounds.Dy())
+		for gx := 0; gx < previewGridWidth; gx++ {
+			x0, x1 := cellPixels(gx, previewGridWidth, bounds.Dx())
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					// ITU-R BT.601 luma, the same weights color.GrayModel uses.
+					sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+				}
+			}
+			grid[gy*previewGridWidth+gx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return grid
+}
+
+// cellPixels returns the range of pixels, of size in total, covered by the
+// given one of n cells. The range holds at least one pixel.
+func cellPixels(cell int, n int, size int) (int, int) {
+	start := (cell*size + n - 1) / n
+	end := ((cell+1)*size + n - 1) / n
+	if end <= start {
+		start = cell * size / n
+		end = start + 1
+	}
+	return start, end
+}
+
+func gridDifference(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return math.Inf(1)
+	}
+	var diff float64
+	for i := range a {
+		diff += math.Abs(a[i] - b[i])
+	}
+	return diff / float64(len(a))
+}
@@ -0,0 +1,99 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestLuminanceGrid(t *testing.T) {
+	// split is white on the left half and black on the right.
+	split := func(width, height int) image.Image {
+		img := image.NewGray(image.Rect(10, 10, 10+width, 10+height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width/2; x++ {
+				img.SetGray(10+x, 10+y, color.Gray{Y: 0xff})
+			}
+		}
+		return img
+	}
+	tests := []struct {
+		name      string
+		img       image.Image
+		luminance float64
+	}{
+		{"full size", split(1280, 720), 0.5},
+		{"grid size", split(32, 18), 0.5},
+		{"smaller than the grid", split(4, 2), 0.5},
+		{"two pixels", split(2, 1), 0.5},
+		{"single pixel", split(1, 1), 0},
+		{"empty", image.NewGray(image.Rect(0, 0, 0, 0)), 0},
+	}
+	for _, test := range tests {
+		grid := luminanceGrid(test.img)
+		if len(grid) != previewGridWidth*previewGridHeight {
+			t.Fatalf("%s: got %d cells, want %d", test.name, len(grid), previewGridWidth*previewGridHeight)
+		}
+		var sum float64
+		for _, l := range grid {
+			sum += l
+		}
+		if got := sum / float64(len(grid)); math.Abs(got-test.luminance) > 1e-6 {
+			t.Errorf("%s: got average luminance %f, want %f", test.name, got, test.luminance)
+		}
+	}
+
+	// Every cell of the grid is sampled from the matching part of a small
+	// image.
+	grid := luminanceGrid(split(4, 2))
+	corners := []float64{grid[0], grid[previewGridWidth-1], grid[len(grid)-previewGridWidth], grid[len(grid)-1]}
+	if corners[0] < 0.999 || corners[1] != 0 || corners[2] < 0.999 || corners[3] != 0 {
+		t.Errorf("corner cells of a small image are %v, want white on the left and black on the right", corners)
+	}
+}
+
+func TestCellPixels(t *testing.T) {
+	tests := []struct {
+		cell, n, size int
+		start, end    int
+	}{
+		{0, 32, 1280, 0, 40},
+		{31, 32, 1280, 1240, 1280},
+		{0, 18, 1080, 0, 60},
+		{0, 32, 4, 0, 1},
+		{7, 32, 4, 0, 1},
+		{8, 32, 4, 1, 2},
+		{31, 32, 4, 3, 4},
+		{0, 32, 50, 0, 2},
+		{1, 32, 50, 2, 4},
+	}
+	for _, test := range tests {
+		start, end := cellPixels(test.cell, test.n, test.size)
+		if start != test.start || end != test.end {
+			t.Errorf("cellPixels(%d, %d, %d) = %d, %d, want %d, %d", test.cell, test.n, test.size, start, end, test.start, test.end)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// wrapTransport wraps the transport of every client, to record or replay
+// the responses of the device API.
+var wrapTransport = func(rt http.RoundTripper) http.RoundTripper { return rt }
+
+// RecordResponses stores every raw response of the device API below dir, in
+// a directory per target, with credentials redacted. Requests still go to the
+// devices. It must be called before any request is made.
+func RecordResponses(dir string) {
+	wrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &RecordingTransport{Dir: dir, Next: rt}
+	}
+	client = newClient(insecureTLSConfig)
+	ResetClients()
+}
+
+// ReplayResponses serves the device API from the responses recorded below
+// dir instead of contacting the devices. It must be called before any
+// request is made.
+func ReplayResponses(dir string) {
+	wrapTransport = func(http.RoundTripper) http.RoundTripper {
+		return &ReplayTransport{Dir: dir}
+	}
+	client = newClient(insecureTLSConfig)
+	ResetClients()
+}
+
+// RecordingTransport stores the responses of the requests it passes on to
+// Next in Dir.
+type RecordingTransport struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	_, password, _ := req.BasicAuth()
+	if err := t.record(resp, password); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error recording response: %s", err)
+	}
+	return resp, nil
+}
+
+// record writes the response to its file. The body of the response is read
+// and replaced, so it can still be used by the caller.
+func (t *RecordingTransport) record(resp *http.Response, password string) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := *resp
+	recorded.Header = resp.Header.Clone()
+	recorded.Header.Del("Set-Cookie")
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		body = redact(body, password)
+	}
+	recorded.Body = io.NopCloser(bytes.NewReader(body))
+	recorded.ContentLength = int64(len(body))
+	recorded.TransferEncoding = nil
+	dump, err := httputil.DumpResponse(&recorded, true)
+	if err != nil {
+		return err
+	}
+
+	file, err := responseFile(t.Dir, resp.Request)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	// Responses of the same endpoint may be recorded concurrently by the
+	// probe handler and the sampler, the last one wins.
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".response-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(dump); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// secretField matches JSON string fields that may hold credentials.
+var secretField = regexp.MustCompile(`(?i)("[a-z_]*(?:password|passwd|secret|token)[a-z_]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// redact removes the password of the request and the values of secret
+// fields from a response body. The password is replaced whatever its length,
+// even if a short one garbles the rest of the body.
+func redact(body []byte, password string) []byte {
+	if password != "" {
+		body = bytes.ReplaceAll(body, []byte(password), []byte("REDACTED"))
+	}
+	return secretField.ReplaceAll(body, []byte(`$1"REDACTED"`))
+}
+
+// ReplayTransport serves requests from the responses recorded in Dir by a
+// RecordingTransport. Requests without a recorded response get a 404.
+type ReplayTransport struct {
+	Dir string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	file, err := responseFile(t.Dir, req)
+	if err != nil {
+		return nil, err
+	}
+	dump, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		body := `{"status":"error","message":"not recorded"}`
+		return &http.Response{
+			Status:        "404 Not Found",
+			StatusCode:    http.StatusNotFound,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+}
+
+// responseFile returns the file of the response to the request, e.g.
+// dir/pearl.local/GET_api_system_firmware_version.http. The file is always
+// below dir.
+func responseFile(dir string, req *http.Request) (string, error) {
+	name := req.Method + "_" + strings.ReplaceAll(strings.Trim(req.URL.Path, "/"), "/", "_")
+	if req.URL.RawQuery != "" {
+		name += "_" + url.QueryEscape(req.URL.RawQuery)
+	}
+	file := filepath.Join(dir, safeName(req.URL.Host), safeName(name)+".http")
+	if rel, err := filepath.Rel(dir, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("response file of %s is outside of %s", req.URL, dir)
+	}
+	return file, nil
+}
+
+// safeName replaces characters that are not safe in file names. Names of
+// dots only, such as "..", are replaced as a whole.
+func safeName(name string) string {
+	if strings.Trim(name, ".") == "" {
+		return strings.Repeat("_", len(name)+1)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '%':
+			return r
+		}
+		return '_'
+	}, name)
+}
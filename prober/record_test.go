@@ -0,0 +1,91 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestResponseFile(t *testing.T) {
+	dir := filepath.Join("testdata", "record")
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://pearl.local/api/system/firmware/version", "pearl.local/GET_api_system_firmware_version.http"},
+		{"https://pearl.local:8443/api/channels?limit=1", "pearl.local_8443/GET_api_channels_limit%3D1.http"},
+		{"http://../api", "___/GET_api.http"},
+		{"http://./api", "__/GET_api.http"},
+		{"http://..foo/api", "..foo/GET_api.http"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := responseFile(dir, req)
+		if err != nil {
+			t.Errorf("responseFile(%s) failed: %s", test.url, err)
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(test.want)); got != want {
+			t.Errorf("responseFile(%s) = %s, want %s", test.url, got, want)
+		}
+	}
+
+	// The host of a request need not be a valid host name.
+	req, _ := http.NewRequest("GET", "http://pearl.local/api", nil)
+	for _, host := range []string{"..", ".", "", "../..", "a/../.."} {
+		req.URL.Host = host
+		file, err := responseFile(dir, req)
+		if err != nil {
+			t.Errorf("responseFile() with host %q failed: %s", host, err)
+			continue
+		}
+		if filepath.Dir(filepath.Dir(file)) != dir {
+			t.Errorf("responseFile() with host %q = %s, want a file in a directory below %s", host, file, dir)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		body     string
+		password string
+		want     string
+	}{
+		{`{"result":"hunter22"}`, "hunter22", `{"result":"REDACTED"}`},
+		{`{"result":"pw"}`, "pw", `{"result":"REDACTED"}`},
+		{`{"result":"x"}`, "", `{"result":"x"}`},
+		{`{"password":"other", "stream_token": "abc\"def"}`, "", `{"password":"REDACTED", "stream_token": "REDACTED"}`},
+		{`{"Admin_Passwd":"other"}`, "", `{"Admin_Passwd":"REDACTED"}`},
+		{`{"name":"password"}`, "", `{"name":"password"}`},
+	}
+	for _, test := range tests {
+		if got := string(redact([]byte(test.body), test.password)); got != test.want {
+			t.Errorf("redact(%s, %q) = %s, want %s", test.body, test.password, got, test.want)
+		}
+	}
+}
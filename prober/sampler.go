@@ -0,0 +1,260 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// AudioSilenceState accumulates how long an audio source has been silent.
+type AudioSilenceState struct {
+	Silent         bool
+	SilenceSeconds float64
+}
+
+// SignalLossState accumulates how long and how often a channel lost its
+// video signal.
+type SignalLossState struct {
+	NoSignal          bool
+	SignalLossSeconds float64
+	SignalLossEvents  float64
+}
+
+// Sampler polls channel and audio status of probed targets on a short
+// interval between scrapes, so sustained silence and signal loss can be
+// reported as counters instead of single samples. Targets are sampled once
+// they have been probed and are dropped again after expiry without probes.
+type Sampler struct {
+	interval time.Duration
+	expiry   time.Duration
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	targets map[string]*sampledTarget
+}
+
+type sampledTarget struct {
+	mtx              sync.Mutex
+	user             string
+	password         string
+	audioSources     []string
+	silenceThreshold float64
+	lastProbe        time.Time
+	lastSample       time.Time
+	audio            map[string]*AudioSilenceState
+	video            map[string]*SignalLossState
+}
+
+func NewSampler(interval time.Duration, expiry time.Duration, logger log.Logger) *Sampler {
+	return &Sampler{
+		interval: interval,
+		expiry:   expiry,
+		logger:   logger,
+		targets:  map[string]*sampledTarget{},
+	}
+}
+
+// Track registers a probe of the target, starting to sample it if it is not
+// sampled yet. The credentials, audio sources and silence threshold of the
+// latest probe are used for subsequent samples. The audio sources are those
+// of the model profile of the device; when nil they are read from the
+// device.
+func (s *Sampler) Track(target string, user string, password string, audioSources []string, silenceThreshold float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t, ok := s.targets[target]
+	if !ok {
+		t = &sampledTarget{
+			audio: map[string]*AudioSilenceState{},
+			video: map[string]*SignalLossState{},
+		}
+		s.targets[target] = t
+	}
+	t.mtx.Lock()
+	t.user = user
+	t.password = password
+	t.audioSources = audioSources
+	t.silenceThreshold = silenceThreshold
+	t.lastProbe = time.Now()
+	t.mtx.Unlock()
+
+	if !ok {
+		level.Info(s.logger).Log("msg", "Start sampling target", "target", target, "interval", s.interval)
+		go s.run(target, t)
+	}
+}
+
+// Retain stops sampling the targets for which keep returns false, e.g. after
+// they were removed from the allowed targets.
+func (s *Sampler) Retain(keep func(target string) bool) {
+	s.mtx.Lock()
+	targets := make([]string, 0, len(s.targets))
+	for target := range s.targets {
+		targets = append(targets, target)
+	}
+	s.mtx.Unlock()
+
+	for _, target := range targets {
+		if keep(target) {
+			continue
+		}
+		s.mtx.Lock()
+		delete(s.targets, target)
+		s.mtx.Unlock()
+		level.Info(s.logger).Log("msg", "Stop sampling target, it is no longer allowed", "target", target)
+	}
+}
+
+// Snapshot returns a copy of the accumulated state of the target.
+func (s *Sampler) Snapshot(target string) (map[string]AudioSilenceState, map[string]SignalLossState, bool) {
+	s.mtx.Lock()
+	t, ok := s.targets[target]
+	s.mtx.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	audio := make(map[string]AudioSilenceState, len(t.audio))
+	for source, state := range t.audio {
+		audio[source] = *state
+	}
+	video := make(map[string]SignalLossState, len(t.video))
+	for channel, state := range t.video {
+		video[channel] = *state
+	}
+	return audio, video, true
+}
+
+func (s *Sampler) run(target string, t *sampledTarget) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.mtx.Lock()
+		t.mtx.Lock()
+		// The target is no longer tracked if it was dropped by Retain.
+		dropped := s.targets[target] != t
+		expired := time.Since(t.lastProbe) > s.expiry
+		if expired && !dropped {
+			delete(s.targets, target)
+		}
+		user, password, audioSources, threshold := t.user, t.password, t.audioSources, t.silenceThreshold
+		t.mtx.Unlock()
+		s.mtx.Unlock()
+		if dropped {
+			return
+		}
+		if expired {
+			level.Info(s.logger).Log("msg", "Stop sampling target, no recent probes", "target", target)
+			return
+		}
+
+		s.sample(target, t, user, password, audioSources, threshold)
+		<-ticker.C
+	}
+}
+
+func (s *Sampler) sample(target string, t *sampledTarget, user string, password string, audioSources []string, threshold float64) {
+	now := time.Now()
+	channels, channelsErr := GetChannelInfo(target, user, password)
+	if channelsErr != nil {
+		level.Debug(s.logger).Log("msg", "Unable to sample channels", "target", target, "err", channelsErr)
+	}
+
+	if audioSources == nil {
+		sources, err := GetSources(target, user, password)
+		if err != nil {
+			level.Debug(s.logger).Log("msg", "Unable to sample sources", "target", target, "err", err)
+		} else {
+			for _, source := range sources.Result {
+				if source.Audio {
+					audioSources = append(audioSources, source.Id)
+				}
+			}
+		}
+	}
+	silent := map[string]bool{}
+	for _, source := range audioSources {
+		levels, err := GetAudioLevels(target, user, password, source)
+		if err != nil || len(levels.Result.Peak) == 0 {
+			continue
+		}
+		silent[source] = levels.Result.Silent(threshold)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	// The time since the previous sample is attributed to the state observed
+	// then; the first sample only establishes a state.
+	var elapsed float64
+	if !t.lastSample.IsZero() {
+		elapsed = now.Sub(t.lastSample).Seconds()
+	}
+	t.lastSample = now
+
+	for source, state := range t.audio {
+		if state.Silent {
+			state.SilenceSeconds += elapsed
+		}
+		if _, ok := silent[source]; !ok {
+			state.Silent = false
+		}
+	}
+	for source, isSilent := range silent {
+		state, ok := t.audio[source]
+		if !ok {
+			state = &AudioSilenceState{}
+			t.audio[source] = state
+		}
+		state.Silent = isSilent
+	}
+
+	if channelsErr != nil {
+		return
+	}
+	for _, state := range t.video {
+		if state.NoSignal {
+			state.SignalLossSeconds += elapsed
+		}
+	}
+	for _, channel := range channels.Result {
+		state, ok := t.video[channel.Id]
+		if !ok {
+			state = &SignalLossState{}
+			t.video[channel.Id] = state
+		}
+		noSignal := channel.Status.Nosignal != nil && *channel.Status.Nosignal != 0
+		if noSignal && !state.NoSignal {
+			state.SignalLossEvents++
+		}
+		state.NoSignal = noSignal
+	}
+}
@@ -0,0 +1,120 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func TestSamplerAccumulates(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		noSignal float64
+		peak     float64
+	)
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch r.URL.Path {
+		case "/api/channels/status":
+			fmt.Fprintf(w, `{"status":"ok","result":[{"id":"1","status":{"state":"started","nosignal":%g}}]}`, noSignal)
+		case "/api/sources":
+			fmt.Fprint(w, `{"status":"ok","result":[{"id":"analog-a","audio":true},{"id":"hdmi-a","video":true}]}`)
+		case "/api/sources/analog-a/audiolevels":
+			fmt.Fprintf(w, `{"status":"ok","result":{"peak":[%g,%g],"rms":[-70,-70]}}`, peak, peak)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer device.Close()
+
+	s := NewSampler(time.Hour, time.Hour, log.NewNopLogger())
+	target := &sampledTarget{audio: map[string]*AudioSilenceState{}, video: map[string]*SignalLossState{}}
+	s.targets[device.URL] = target
+
+	// Every step samples the device after the given time, which counts
+	// toward the state observed by the previous step.
+	steps := []struct {
+		after          time.Duration
+		noSignal       float64
+		peak           float64
+		silenceSeconds float64
+		lossSeconds    float64
+		lossEvents     float64
+	}{
+		{0, 0, -12, 0, 0, 0},
+		{10 * time.Second, 1, -80, 0, 0, 1},
+		{10 * time.Second, 1, -80, 10, 10, 1},
+		{5 * time.Second, 0, -12, 15, 15, 1},
+		{10 * time.Second, 1, -60, 15, 15, 2},
+		// A peak at the threshold is not silent.
+		{10 * time.Second, 1, -60, 15, 25, 2},
+	}
+	for i, step := range steps {
+		mtx.Lock()
+		noSignal, peak = step.noSignal, step.peak
+		mtx.Unlock()
+		if i > 0 {
+			target.lastSample = time.Now().Add(-step.after)
+		}
+		s.sample(device.URL, target, "", "", nil, -60)
+
+		audio, video, ok := s.Snapshot(device.URL)
+		if !ok {
+			t.Fatalf("step %d: target is not sampled", i)
+		}
+		if got := audio["analog-a"].SilenceSeconds; got < step.silenceSeconds || got > step.silenceSeconds+1 {
+			t.Errorf("step %d: got %.1fs of silence, want %gs", i, got, step.silenceSeconds)
+		}
+		if got := audio["analog-a"].Silent; got != (step.peak < -60) {
+			t.Errorf("step %d: got silent %t, want %t", i, got, step.peak < -60)
+		}
+		if got := video["1"].SignalLossSeconds; got < step.lossSeconds || got > step.lossSeconds+1 {
+			t.Errorf("step %d: got %.1fs of signal loss, want %gs", i, got, step.lossSeconds)
+		}
+		if got := video["1"].SignalLossEvents; got != step.lossEvents {
+			t.Errorf("step %d: got %g signal loss events, want %g", i, got, step.lossEvents)
+		}
+	}
+}
+
+func TestSamplerRetain(t *testing.T) {
+	s := NewSampler(time.Hour, time.Hour, log.NewNopLogger())
+	for _, target := range []string{"pearl-1.local", "pearl-2.local"} {
+		s.targets[target] = &sampledTarget{audio: map[string]*AudioSilenceState{}, video: map[string]*SignalLossState{}}
+	}
+	s.Retain(func(target string) bool { return target == "pearl-1.local" })
+	if _, _, ok := s.Snapshot("pearl-1.local"); !ok {
+		t.Error("a retained target was dropped")
+	}
+	if _, _, ok := s.Snapshot("pearl-2.local"); ok {
+		t.Error("a target that is no longer allowed is still sampled")
+	}
+}
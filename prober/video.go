@@ -0,0 +1,96 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ParseResolution splits a resolution such as "1920x1080" into its width and
+// height. ok is false unless both are positive decimal numbers.
+func ParseResolution(resolution string) (width int, height int, ok bool) {
+	w, h, found := strings.Cut(resolution, "x")
+	if !found {
+		return 0, 0, false
+	}
+	width, height = parseDimension(w), parseDimension(h)
+	if width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// parseDimension parses a number of pixels, or returns 0 if it is not made up
+// of digits only.
+func parseDimension(s string) int {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// ResolutionTracker counts how often the resolution of a source changes
+// across probes.
+type ResolutionTracker struct {
+	mtx     sync.Mutex
+	sources map[string]*resolutionState
+}
+
+type resolutionState struct {
+	resolution string
+	changes    float64
+}
+
+func NewResolutionTracker() *ResolutionTracker {
+	return &ResolutionTracker{
+		sources: map[string]*resolutionState{},
+	}
+}
+
+// Observe records the current resolution of a source and returns the number
+// of changes seen so far. Empty resolutions, reported while there is no
+// signal, are not considered a change.
+func (t *ResolutionTracker) Observe(target string, source string, resolution string) float64 {
+	key := target + "\x00" + source
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	state, ok := t.sources[key]
+	if !ok {
+		state = &resolutionState{resolution: resolution}
+		t.sources[key] = state
+	}
+	if resolution != "" && resolution != state.resolution {
+		if state.resolution != "" {
+			state.changes++
+		}
+		state.resolution = resolution
+	}
+	return state.changes
+}
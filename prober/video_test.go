@@ -0,0 +1,84 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package prober
+
+import "testing"
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		resolution string
+		width      int
+		height     int
+		ok         bool
+	}{
+		{"1920x1080", 1920, 1080, true},
+		{"3840x2160", 3840, 2160, true},
+		{"720x576", 720, 576, true},
+		{"", 0, 0, false},
+		{"1920", 0, 0, false},
+		{"1920x", 0, 0, false},
+		{"x1080", 0, 0, false},
+		{"1920X1080", 0, 0, false},
+		{"1920 x 1080", 0, 0, false},
+		{" 1920x1080", 0, 0, false},
+		{"1920x1080i", 0, 0, false},
+		{"1920x1080x60", 0, 0, false},
+		{"-1920x1080", 0, 0, false},
+		{"1920x+1080", 0, 0, false},
+		{"0x0", 0, 0, false},
+		{"99999999999999999999x1080", 0, 0, false},
+	}
+	for _, test := range tests {
+		width, height, ok := ParseResolution(test.resolution)
+		if width != test.width || height != test.height || ok != test.ok {
+			t.Errorf("ParseResolution(%q) = %d, %d, %t, want %d, %d, %t", test.resolution, width, height, ok, test.width, test.height, test.ok)
+		}
+	}
+}
+
+func TestResolutionTracker(t *testing.T) {
+	tracker := NewResolutionTracker()
+	steps := []struct {
+		resolution string
+		changes    float64
+	}{
+		{"", 0},
+		{"1920x1080", 0},
+		{"1920x1080", 0},
+		// Losing the signal is not a change, nor is getting it back at the
+		// same resolution.
+		{"", 0},
+		{"1920x1080", 0},
+		{"1280x720", 1},
+		{"", 1},
+		{"1920x1080", 2},
+	}
+	for i, step := range steps {
+		if got := tracker.Observe("pearl.local", "hdmi-a", step.resolution); got != step.changes {
+			t.Errorf("step %d: Observe(%q) = %v, want %v", i, step.resolution, got, step.changes)
+		}
+	}
+	if got := tracker.Observe("pearl.local", "sdi", "1280x720"); got != 0 {
+		t.Errorf("changes of another source = %v, want 0", got)
+	}
+}
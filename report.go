@@ -0,0 +1,123 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/prober"
+)
+
+// firmwareRecord is the firmware state of a device as seen by its latest
+// successful probe.
+type firmwareRecord struct {
+	Target           string    `json:"target"`
+	Model            string    `json:"model"`
+	Serial           string    `json:"serial"`
+	FirmwareVersion  string    `json:"firmware_version"`
+	AvailableVersion string    `json:"available_version"`
+	MinimumVersion   string    `json:"minimum_version"`
+	ApprovedVersions []string  `json:"approved_versions"`
+	Compliant        *bool     `json:"compliant"`
+	LastProbe        time.Time `json:"last_probe"`
+}
+
+// firmwareReport keeps the latest firmware record per target for the fleet
+// report.
+type firmwareReport struct {
+	mtx     sync.Mutex
+	records map[string]firmwareRecord
+}
+
+func newFirmwareReport() *firmwareReport {
+	return &firmwareReport{
+		records: map[string]firmwareRecord{},
+	}
+}
+
+func (f *firmwareReport) update(record firmwareRecord) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.records[record.Target] = record
+}
+
+func (f *firmwareReport) list() []firmwareRecord {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	records := make([]firmwareRecord, 0, len(f.records))
+	for _, record := range f.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Target < records[j].Target
+	})
+	return records
+}
+
+// ServeHTTP writes the fleet table as JSON, or as CSV with ?format=csv.
+func (f *firmwareReport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	records := f.list()
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"target", "model", "serial", "firmware_version", "available_version", "minimum_version", "approved_versions", "compliant", "last_probe"})
+		for _, record := range records {
+			compliant := ""
+			if record.Compliant != nil {
+				compliant = strconv.FormatBool(*record.Compliant)
+			}
+			cw.Write([]string{record.Target, record.Model, record.Serial, record.FirmwareVersion, record.AvailableVersion,
+				record.MinimumVersion, strings.Join(record.ApprovedVersions, " "), compliant, record.LastProbe.Format(time.RFC3339)})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "Unknown format, use json or csv", http.StatusBadRequest)
+	}
+}
+
+// firmwareCompliant reports whether version is approved by the policy, either
+// by being listed explicitly or by being at least the minimum version. An
+// unknown version is never compliant.
+func firmwareCompliant(policy *config.FirmwarePolicy, version string) bool {
+	if version == "" {
+		return false
+	}
+	for _, approved := range policy.Versions {
+		if prober.CompareFirmwareVersions(version, approved) == 0 {
+			return true
+		}
+	}
+	return policy.MinimumVersion != "" && prober.CompareFirmwareVersions(version, policy.MinimumVersion) >= 0
+}
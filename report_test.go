@@ -0,0 +1,58 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mm-dict/pearl-exporter/config"
+)
+
+func TestFirmwareCompliant(t *testing.T) {
+	tests := []struct {
+		policy  config.FirmwarePolicy
+		version string
+		want    bool
+	}{
+		{config.FirmwarePolicy{MinimumVersion: "4.14.2"}, "4.14.2", true},
+		{config.FirmwarePolicy{MinimumVersion: "4.14.2"}, "4.14.3", true},
+		{config.FirmwarePolicy{MinimumVersion: "4.14.2"}, "4.14.1", false},
+		{config.FirmwarePolicy{MinimumVersion: "4.14"}, "4.14.0", true},
+		{config.FirmwarePolicy{MinimumVersion: "4.14.0"}, "4.14", true},
+		{config.FirmwarePolicy{MinimumVersion: "4.15.0"}, "4.15.0-rc1", false},
+		{config.FirmwarePolicy{MinimumVersion: "4.15.0"}, "4.15.0 build 5123", true},
+		{config.FirmwarePolicy{MinimumVersion: "0"}, "", false},
+		{config.FirmwarePolicy{Versions: []string{"4.12.1", "4.14.2"}}, "4.14.2", true},
+		{config.FirmwarePolicy{Versions: []string{"4.12.1", "4.14.2"}}, "4.14.3", false},
+		{config.FirmwarePolicy{Versions: []string{"4.12.1", "4.14.2"}}, "4.13.0", false},
+		{config.FirmwarePolicy{Versions: []string{"4.12"}}, "4.12.0", true},
+		{config.FirmwarePolicy{MinimumVersion: "4.14.2", Versions: []string{"4.12.1"}}, "4.12.1", true},
+		{config.FirmwarePolicy{MinimumVersion: "4.14.2", Versions: []string{"4.12.1"}}, "4.13.0", false},
+		{config.FirmwarePolicy{}, "4.14.2", false},
+	}
+	for _, test := range tests {
+		if got := firmwareCompliant(&test.policy, test.version); got != test.want {
+			t.Errorf("firmwareCompliant(%+v, %q) = %t, want %t", test.policy, test.version, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a VEVENT of an iCalendar file. Recurring events are expanded with
+// Occurrences.
+type Event struct {
+	UID      string
+	Summary  string
+	Start    time.Time
+	Duration time.Duration
+
+	rule    *recurrence
+	exdates map[int64]bool
+}
+
+// recurrence holds the supported subset of an RRULE: daily and weekly
+// repetition with INTERVAL, COUNT, UNTIL and, for weekly rules, BYDAY.
+type recurrence struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+// Parse reads the events of an iCalendar file. Cancelled events are left out
+// and modified occurrences of a recurring event (RECURRENCE-ID) replace the
+// original occurrence. Events that cannot be read, e.g. because they repeat
+// monthly, are left out as well and returned as skipped, so one of them does
+// not make the whole calendar unusable.
+func Parse(r io.Reader) ([]*Event, []error, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		events    []*Event
+		skipped   []error
+		overrides = map[string][]time.Time{}
+		current   *Event
+		begin     int
+		depth     int
+		skip      error
+		cancelled bool
+		override  time.Time
+		end       time.Time
+		hasEnd    bool
+	)
+	for n, line := range lines {
+		name, params, value, err := splitProperty(line)
+		if err != nil {
+			if current == nil {
+				return nil, nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+			if skip == nil {
+				skip = fmt.Errorf("line %d: %s", n+1, err)
+			}
+			continue
+		}
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			current = &Event{exdates: map[int64]bool{}}
+			begin, depth = n+1, 0
+			skip, cancelled, override, end, hasEnd = nil, false, time.Time{}, time.Time{}, false
+			continue
+		case name == "END" && value == "VEVENT":
+			if current == nil {
+				return nil, nil, fmt.Errorf("line %d: END:VEVENT without BEGIN:VEVENT", n+1)
+			}
+			if skip == nil && current.Start.IsZero() {
+				skip = fmt.Errorf("line %d: no DTSTART", n+1)
+			}
+			if skip != nil {
+				skipped = append(skipped, fmt.Errorf("event %q at line %d skipped: %s", current.UID, begin, skip))
+				current = nil
+				continue
+			}
+			if hasEnd {
+				current.Duration = end.Sub(current.Start)
+			}
+			if !override.IsZero() {
+				overrides[current.UID] = append(overrides[current.UID], override)
+			}
+			if !cancelled {
+				events = append(events, current)
+			}
+			current = nil
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		// Properties of components nested in the event, such as VALARM,
+		// are not properties of the event.
+		switch name {
+		case "BEGIN":
+			depth++
+			continue
+		case "END":
+			if depth > 0 {
+				depth--
+			}
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			current.UID = value
+		case "SUMMARY":
+			current.Summary = unescape(value)
+		case "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case "DTSTART":
+			current.Start, err = parseDateTime(value, params)
+		case "DTEND":
+			end, err = parseDateTime(value, params)
+			hasEnd = true
+		case "DURATION":
+			current.Duration, err = parseDuration(value)
+		case "RRULE":
+			current.rule, err = parseRule(value)
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				var t time.Time
+				if t, err = parseDateTime(v, params); err != nil {
+					break
+				}
+				current.exdates[t.Unix()] = true
+			}
+		case "RECURRENCE-ID":
+			override, err = parseDateTime(value, params)
+		}
+		if err != nil && skip == nil {
+			skip = fmt.Errorf("line %d: %s: %s", n+1, name, err)
+		}
+	}
+
+	// A modified occurrence is listed as an event of its own, so the original
+	// occurrence of the recurring event is skipped.
+	for _, event := range events {
+		if event.rule == nil {
+			continue
+		}
+		for _, t := range overrides[event.UID] {
+			event.exdates[t.Unix()] = true
+		}
+	}
+	return events, skipped, nil
+}
+
+// Occurrences calls fn with the start of every occurrence of the event that
+// starts before the given time, in order, until fn returns false.
+func (e *Event) Occurrences(before time.Time, fn func(start time.Time) bool) {
+	if e.rule == nil {
+		if e.Start.Before(before) {
+			fn(e.Start)
+		}
+		return
+	}
+
+	emitted := 0
+	emit := func(start time.Time) bool {
+		if !e.rule.until.IsZero() && start.After(e.rule.until) {
+			return false
+		}
+		if e.rule.count > 0 && emitted >= e.rule.count {
+			return false
+		}
+		if !start.Before(before) {
+			return false
+		}
+		emitted++
+		if e.exdates[start.Unix()] {
+			return true
+		}
+		return fn(start)
+	}
+
+	switch e.rule.freq {
+	case "DAILY":
+		for i := 0; ; i += e.rule.interval {
+			if !emit(e.Start.AddDate(0, 0, i)) {
+				return
+			}
+		}
+	case "WEEKLY":
+		days := e.rule.byDay
+		if len(days) == 0 {
+			days = []time.Weekday{e.Start.Weekday()}
+		}
+		// Weeks start on Monday.
+		monday := e.Start.AddDate(0, 0, -((int(e.Start.Weekday()) + 6) % 7))
+		for week := 0; ; week += e.rule.interval {
+			for _, day := range days {
+				start := monday.AddDate(0, 0, week*7+(int(day)+6)%7)
+				if start.Before(e.Start) {
+					continue
+				}
+				if !emit(start) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// unfold reads the content lines of an iCalendar file, joining lines that
+// are continued on the next line.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitProperty splits a content line such as
+// DTSTART;TZID=Europe/Brussels:20221017T090000 into its name, parameters and
+// value.
+func splitProperty(line string) (string, map[string]string, string, error) {
+	if line == "" {
+		return "", nil, "", nil
+	}
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", fmt.Errorf("missing ':' in %q", line)
+	}
+	parts := strings.Split(line[:colon], ";")
+	params := map[string]string{}
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], nil
+}
+
+// parseDateTime parses a DATE or DATE-TIME value. Times without a zone or
+// TZID are in local time.
+func parseDateTime(value string, params map[string]string) (time.Time, error) {
+	loc := time.Local
+	if tzid, ok := params["TZID"]; ok {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+		}
+		loc = l
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseDuration parses a duration value such as PT1H30M or P1D.
+func parseDuration(value string) (time.Duration, error) {
+	v := strings.TrimPrefix(value, "+")
+	if !strings.HasPrefix(v, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	v = v[1:]
+	var d time.Duration
+	inTime := false
+	for v != "" {
+		if v[0] == 'T' {
+			inTime = true
+			v = v[1:]
+			continue
+		}
+		i := strings.IndexAny(v, "WDHMS")
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		n, err := strconv.Atoi(v[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		unit := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}[v[i]]
+		if v[i] == 'M' && !inTime {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d += time.Duration(n) * unit
+		v = v[i+1:]
+	}
+	return d, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRule(value string) (*recurrence, error) {
+	rule := &recurrence{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			rule.freq = strings.ToUpper(kv[1])
+			if rule.freq != "DAILY" && rule.freq != "WEEKLY" {
+				return nil, fmt.Errorf("unsupported frequency %q", kv[1])
+			}
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(kv[1])
+			if err == nil && rule.interval < 1 {
+				err = fmt.Errorf("invalid interval %q", kv[1])
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(kv[1])
+		case "UNTIL":
+			rule.until, err = parseDateTime(kv[1], nil)
+		case "BYDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY %q", day)
+				}
+				rule.byDay = append(rule.byDay, weekday)
+			}
+			// Occurrences are generated in order from Monday.
+			sort.Slice(rule.byDay, func(i, j int) bool {
+				return (rule.byDay[i]+6)%7 < (rule.byDay[j]+6)%7
+			})
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", kv[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rule.freq == "" {
+		return nil, fmt.Errorf("rule has no FREQ")
+	}
+	return rule, nil
+}
+
+func unescape(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
@@ -0,0 +1,207 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// calendar wraps the given events, one slice of content lines each.
+func calendar(events ...[]string) string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0"}
+	for _, event := range events {
+		lines = append(lines, "BEGIN:VEVENT")
+		lines = append(lines, event...)
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// starts returns the starts of the occurrences of every event before the
+// given time in UTC, limited to 10 per event.
+func starts(events []*Event, before time.Time) []string {
+	var result []string
+	for _, event := range events {
+		n := 0
+		event.Occurrences(before, func(start time.Time) bool {
+			result = append(result, start.UTC().Format(time.RFC3339))
+			n++
+			return n < 10
+		})
+	}
+	return result
+}
+
+func TestParseOccurrences(t *testing.T) {
+	before := time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		event    []string
+		want     []string
+		duration time.Duration
+	}{
+		{
+			name:     "single",
+			event:    []string{"UID:1", "DTSTART:20221017T090000Z", "DTEND:20221017T103000Z"},
+			want:     []string{"2022-10-17T09:00:00Z"},
+			duration: 90 * time.Minute,
+		},
+		{
+			name:     "duration",
+			event:    []string{"UID:1", "DTSTART:20221017T090000Z", "DURATION:PT1H15M"},
+			want:     []string{"2022-10-17T09:00:00Z"},
+			duration: 75 * time.Minute,
+		},
+		{
+			name:  "daily with count",
+			event: []string{"UID:1", "DTSTART:20221017T090000Z", "RRULE:FREQ=DAILY;COUNT=3"},
+			want:  []string{"2022-10-17T09:00:00Z", "2022-10-18T09:00:00Z", "2022-10-19T09:00:00Z"},
+		},
+		{
+			name:  "daily with interval and until",
+			event: []string{"UID:1", "DTSTART:20221017T090000Z", "RRULE:FREQ=DAILY;INTERVAL=2;UNTIL=20221021T090000Z"},
+			want:  []string{"2022-10-17T09:00:00Z", "2022-10-19T09:00:00Z", "2022-10-21T09:00:00Z"},
+		},
+		{
+			name:  "weekly by day",
+			event: []string{"UID:1", "DTSTART:20221017T090000Z", "RRULE:FREQ=WEEKLY;BYDAY=WE,MO;COUNT=4"},
+			want:  []string{"2022-10-17T09:00:00Z", "2022-10-19T09:00:00Z", "2022-10-24T09:00:00Z", "2022-10-26T09:00:00Z"},
+		},
+		{
+			name:  "weekly with interval",
+			event: []string{"UID:1", "DTSTART:20221018T090000Z", "RRULE:FREQ=WEEKLY;INTERVAL=2;COUNT=3"},
+			want:  []string{"2022-10-18T09:00:00Z", "2022-11-01T09:00:00Z", "2022-11-15T09:00:00Z"},
+		},
+		{
+			name:  "exdate",
+			event: []string{"UID:1", "DTSTART:20221017T090000Z", "RRULE:FREQ=DAILY;COUNT=3", "EXDATE:20221018T090000Z"},
+			want:  []string{"2022-10-17T09:00:00Z", "2022-10-19T09:00:00Z"},
+		},
+		{
+			// The local time stays the same when daylight saving time ends
+			// on October 30.
+			name:  "time zone",
+			event: []string{"UID:1", "DTSTART;TZID=Europe/Brussels:20221028T090000", "RRULE:FREQ=DAILY;COUNT=4", "EXDATE;TZID=Europe/Brussels:20221029T090000"},
+			want:  []string{"2022-10-28T07:00:00Z", "2022-10-30T08:00:00Z", "2022-10-31T08:00:00Z"},
+		},
+		{
+			name:  "until before the end",
+			event: []string{"UID:1", "DTSTART:20221128T090000Z", "RRULE:FREQ=DAILY"},
+			want:  []string{"2022-11-28T09:00:00Z", "2022-11-29T09:00:00Z"},
+		},
+		{
+			name:  "cancelled",
+			event: []string{"UID:1", "DTSTART:20221017T090000Z", "STATUS:CANCELLED"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, skipped, err := Parse(strings.NewReader(calendar(test.event)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(skipped) > 0 {
+				t.Fatalf("events skipped: %v", skipped)
+			}
+			got := starts(events, before)
+			if strings.Join(got, " ") != strings.Join(test.want, " ") {
+				t.Errorf("got occurrences %v, want %v", got, test.want)
+			}
+			if test.duration != 0 && events[0].Duration != test.duration {
+				t.Errorf("got duration %s, want %s", events[0].Duration, test.duration)
+			}
+		})
+	}
+}
+
+func TestParseRecurrenceID(t *testing.T) {
+	events, _, err := Parse(strings.NewReader(calendar(
+		[]string{"UID:1", "DTSTART:20221017T090000Z", "RRULE:FREQ=DAILY;COUNT=3"},
+		[]string{"UID:1", "RECURRENCE-ID:20221018T090000Z", "DTSTART:20221018T140000Z"},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := starts(events, time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC))
+	want := "2022-10-17T09:00:00Z 2022-10-19T09:00:00Z 2022-10-18T14:00:00Z"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got occurrences %v, want %s", got, want)
+	}
+}
+
+func TestParseNestedComponents(t *testing.T) {
+	events, skipped, err := Parse(strings.NewReader(calendar([]string{
+		"UID:1", "SUMMARY:Lecture", "DTSTART:20221017T090000Z", "DURATION:PT2H",
+		"BEGIN:VALARM", "ACTION:DISPLAY", "SUMMARY:Reminder", "DURATION:PT15M",
+		"TRIGGER:-PT15M", "END:VALARM",
+		"STATUS:CONFIRMED",
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 0 || len(events) != 1 {
+		t.Fatalf("got events %v, skipped %v, want one event", events, skipped)
+	}
+	if e := events[0]; e.Summary != "Lecture" || e.Duration != 2*time.Hour {
+		t.Errorf("got summary %q and duration %s, want the properties of the event", e.Summary, e.Duration)
+	}
+}
+
+func TestParseSkipsUnsupportedEvents(t *testing.T) {
+	tests := []struct {
+		name  string
+		event []string
+	}{
+		{"monthly", []string{"UID:monthly", "DTSTART:20221017T090000Z", "RRULE:FREQ=MONTHLY;BYMONTHDAY=17"}},
+		{"ordinal by day", []string{"UID:ordinal", "DTSTART:20221017T090000Z", "RRULE:FREQ=WEEKLY;BYDAY=1MO"}},
+		{"unknown rule part", []string{"UID:bysetpos", "DTSTART:20221017T090000Z", "RRULE:FREQ=WEEKLY;BYSETPOS=1"}},
+		{"unknown time zone", []string{"UID:tz", "DTSTART;TZID=Mars/Olympus:20221017T090000"}},
+		{"missing start", []string{"UID:nostart", "SUMMARY:No start"}},
+		{"malformed line", []string{"UID:malformed", "DTSTART:20221017T090000Z", "garbage"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			supported := []string{"UID:ok", "DTSTART:20221017T090000Z"}
+			events, skipped, err := Parse(strings.NewReader(calendar(test.event, supported)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(skipped) != 1 {
+				t.Errorf("got %d skipped events, want 1: %v", len(skipped), skipped)
+			}
+			if len(events) != 1 || events[0].UID != "ok" {
+				t.Errorf("the supported event was not kept: %v", events)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, _, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\nEND:VEVENT\r\n")); err == nil {
+		t.Error("END:VEVENT without BEGIN:VEVENT was accepted")
+	}
+}
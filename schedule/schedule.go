@@ -0,0 +1,185 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package schedule reads the expected recordings of a device from iCalendar
+// files and tracks recordings that were missed.
+package schedule
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Occurrence is a single scheduled recording.
+type Occurrence struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Calendar holds the events of an iCalendar file.
+type Calendar struct {
+	Events []*Event
+	// Skipped lists the events left out because they could not be read.
+	Skipped []error
+}
+
+// Active returns the occurrences that are running at the given time, or
+// start or end within the grace period around it.
+func (c *Calendar) Active(now time.Time, grace time.Duration) []Occurrence {
+	var active []Occurrence
+	for _, event := range c.Events {
+		event.Occurrences(now.Add(grace), func(start time.Time) bool {
+			end := start.Add(event.Duration)
+			if end.Add(grace).After(now) {
+				active = append(active, Occurrence{UID: event.UID, Summary: event.Summary, Start: start, End: end})
+			}
+			return true
+		})
+	}
+	return active
+}
+
+// Store caches parsed calendar files and parses them again when they change
+// on disk.
+type Store struct {
+	logger log.Logger
+
+	mtx   sync.Mutex
+	files map[string]*calendarFile
+}
+
+type calendarFile struct {
+	modTime  time.Time
+	size     int64
+	calendar *Calendar
+	err      error
+}
+
+// NewStore returns an empty store. Events left out of a calendar are logged
+// whenever it is parsed.
+func NewStore(logger log.Logger) *Store {
+	return &Store{
+		logger: logger,
+		files:  map[string]*calendarFile{},
+	}
+}
+
+// Load returns the calendar in the given file.
+func (s *Store) Load(path string) (*Calendar, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if f, ok := s.files[path]; ok && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+		return f.calendar, f.err
+	}
+	f := &calendarFile{modTime: info.ModTime(), size: info.Size()}
+	f.calendar, f.err = parseFile(path)
+	s.files[path] = f
+	if f.err == nil {
+		for _, err := range f.calendar.Skipped {
+			level.Warn(s.logger).Log("msg", "Event of schedule not supported", "file", path, "err", err)
+		}
+	}
+	return f.calendar, f.err
+}
+
+func parseFile(path string) (*Calendar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	events, skipped, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", path, err)
+	}
+	return &Calendar{Events: events, Skipped: skipped}, nil
+}
+
+// Tracker counts the scheduled recordings of every target that were missed.
+// Each occurrence is counted once, however often it is observed.
+type Tracker struct {
+	mtx     sync.Mutex
+	targets map[string]*missedRecordings
+}
+
+type missedRecordings struct {
+	total float64
+	// seen holds the end of every occurrence counted, until it is long past.
+	seen map[string]time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		targets: map[string]*missedRecordings{},
+	}
+}
+
+// Missed records that the occurrence was not being recorded and returns the
+// number of missed recordings of the target so far.
+func (t *Tracker) Missed(target string, o Occurrence) float64 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	m := t.missed(target)
+	key := o.UID + "/" + o.Start.UTC().Format(time.RFC3339)
+	if _, ok := m.seen[key]; !ok {
+		m.seen[key] = o.End
+		m.total++
+	}
+	return m.total
+}
+
+// Total returns the number of missed recordings of the target so far.
+func (t *Tracker) Total(target string) float64 {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.missed(target).total
+}
+
+func (t *Tracker) missed(target string) *missedRecordings {
+	m, ok := t.targets[target]
+	if !ok {
+		m = &missedRecordings{seen: map[string]time.Time{}}
+		t.targets[target] = m
+	}
+	// Occurrences that ended a day ago will not be observed again.
+	for key, end := range m.seen {
+		if time.Since(end) > 24*time.Hour {
+			delete(m.seen, key)
+		}
+	}
+	return m
+}
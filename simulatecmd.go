@@ -0,0 +1,132 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mm-dict/pearl-exporter/simulator"
+)
+
+// simulateOptions are the flags of the simulate command.
+type simulateOptions struct {
+	listenAddress   string
+	model           string
+	deviceFile      string
+	firmwareVersion string
+	user            string
+	password        string
+	latency         time.Duration
+	faults          []string
+	noSignal        []string
+}
+
+// parseFault parses a fault given as PATH=STATUS or PATH=malformed, e.g.
+// /api/system/status=500. An empty path breaks every request.
+func parseFault(spec string) (simulator.Fault, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return simulator.Fault{}, fmt.Errorf("invalid fault %q, expected PATH=STATUS or PATH=malformed", spec)
+	}
+	fault := simulator.Fault{Path: spec[:i]}
+	if spec[i+1:] == "malformed" {
+		fault.Malformed = true
+		return fault, nil
+	}
+	status, err := strconv.Atoi(spec[i+1:])
+	if err != nil || status < 100 || status > 599 {
+		return simulator.Fault{}, fmt.Errorf("invalid status in fault %q", spec)
+	}
+	fault.Status = status
+	return fault, nil
+}
+
+// simulatedDevice returns the device described by the options.
+func simulatedDevice(opts simulateOptions) (simulator.Device, error) {
+	device, err := simulator.NewDevice(opts.model)
+	if err != nil {
+		return device, err
+	}
+	if opts.deviceFile != "" {
+		b, err := os.ReadFile(opts.deviceFile)
+		if err != nil {
+			return device, fmt.Errorf("error reading device file: %s", err)
+		}
+		if err := yaml.Unmarshal(b, &device); err != nil {
+			return device, fmt.Errorf("error parsing device file: %s", err)
+		}
+	}
+	if opts.firmwareVersion != "" {
+		device.FirmwareVersion = opts.firmwareVersion
+	}
+	if opts.user != "" {
+		device.Username, device.Password = opts.user, opts.password
+	}
+	if opts.latency > 0 {
+		device.Faults = append(device.Faults, simulator.Fault{Latency: opts.latency})
+	}
+	for _, spec := range opts.faults {
+		fault, err := parseFault(spec)
+		if err != nil {
+			return device, err
+		}
+		device.Faults = append(device.Faults, fault)
+	}
+	for _, id := range opts.noSignal {
+		found := false
+		for i := range device.Sources {
+			if device.Sources[i].ID == id {
+				device.Sources[i].NoSignal = true
+				found = true
+			}
+		}
+		if !found {
+			return device, fmt.Errorf("unknown source %q", id)
+		}
+	}
+	return device, nil
+}
+
+// runSimulateCommand serves a simulated device until it fails.
+func runSimulateCommand(opts simulateOptions, logger log.Logger) int {
+	device, err := simulatedDevice(opts)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error setting up simulated device", "err", err)
+		return 1
+	}
+	level.Info(logger).Log("msg", "Simulating device", "product", device.Product, "firmware_version", device.FirmwareVersion, "address", opts.listenAddress)
+	if err := http.ListenAndServe(opts.listenAddress, simulator.New(device)); err != nil {
+		level.Error(logger).Log("msg", "Error serving simulated device", "err", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/simulator"
+)
+
+// TestProbeSimulator probes simulated devices set up like the simulate
+// command does, twice each, and checks for metrics the options affect.
+func TestProbeSimulator(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      simulateOptions
+		probeUser string
+		want      []string
+	}{
+		{
+			name: "healthy",
+			opts: simulateOptions{model: "Pearl Mini"},
+			want: []string{
+				`pearl_probe_success 1`,
+				`pearl_device_info{model="Pearl Mini",product="Pearl Mini",serial="SIM0001"} 1`,
+				`pearl_api_compatibility{endpoint="system_status",status="ok"} 1`,
+				`pearl_channels_info{id="1",status="started",type="nosignal"} 0`,
+				`pearl_audio_silent{source="D2P0.hdmi-a"} 0`,
+			},
+		},
+		{
+			name: "no signal",
+			opts: simulateOptions{model: "Pearl Mini", noSignal: []string{"D2P0.hdmi-a"}},
+			want: []string{
+				`pearl_probe_success 1`,
+				`pearl_channels_info{id="1",status="started",type="nosignal"} 1`,
+				`pearl_channels_info{id="2",status="started",type="nosignal"} 0`,
+				`pearl_audio_silent{source="D2P0.hdmi-a"} 1`,
+				`pearl_audio_silent{source="D2P0.hdmi-b"} 0`,
+			},
+		},
+		{
+			// Firmware before 4.14 reports the system status in the legacy
+			// format.
+			name: "firmware 4.2",
+			opts: simulateOptions{model: "Pearl Mini", firmwareVersion: "4.2.0"},
+			want: []string{
+				`pearl_probe_success 1`,
+				`pearl_api_compatibility{endpoint="system_status",status="ok"} 1`,
+				`pearl_api_compatibility{endpoint="firmware_version",status="ok"} 1`,
+				`pearl_cpu_load_ratio 0.23`,
+			},
+		},
+		{
+			// Firmware 3 lacks most endpoints, but can still be probed.
+			name: "firmware 3.20",
+			opts: simulateOptions{model: "Pearl Mini", firmwareVersion: "3.20.0"},
+			want: []string{
+				`pearl_probe_success 1`,
+				`pearl_device_info{model="Pearl Mini",product="Pearl Mini",serial="SIM0001"} 1`,
+				`pearl_api_compatibility{endpoint="firmware_version",status="ok"} 1`,
+				`pearl_api_compatibility{endpoint="device_info",status="ok"} 1`,
+				`pearl_api_compatibility{endpoint="system_status",status="unsupported"} 1`,
+			},
+		},
+		{
+			name:      "credentials",
+			opts:      simulateOptions{model: "Pearl Mini", user: "admin", password: "secret"},
+			probeUser: "admin",
+			want:      []string{`pearl_probe_success 1`},
+		},
+		{
+			name: "wrong credentials",
+			opts: simulateOptions{model: "Pearl Mini", user: "admin", password: "secret"},
+			want: []string{`pearl_probe_success 0`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device, err := simulatedDevice(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(simulator.New(device))
+			defer server.Close()
+			resetProbeState()
+
+			c, err := config.LoadFile("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := url.Values{"target": {server.URL}}
+			if test.probeUser != "" {
+				params.Set("user", test.probeUser)
+				params.Set("password", test.opts.password)
+			}
+			for i := 1; i <= 2; i++ {
+				req := httptest.NewRequest("GET", "/probe?"+params.Encode(), nil)
+				rec := httptest.NewRecorder()
+				probeHandler(rec, req, c, log.NewNopLogger())
+				lines := map[string]bool{}
+				for _, line := range strings.Split(rec.Body.String(), "\n") {
+					lines[line] = true
+				}
+				for _, want := range test.want {
+					if !lines[want] {
+						t.Errorf("probe %d: missing %s", i, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatedDeviceUnknownSource(t *testing.T) {
+	if _, err := simulatedDevice(simulateOptions{model: "Pearl Mini", noSignal: []string{"D2P0.nope"}}); err == nil {
+		t.Error("an unknown no-signal source was accepted")
+	}
+}
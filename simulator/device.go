@@ -0,0 +1,234 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package simulator serves a fake Epiphan Pearl REST API, for developing and
+// testing the exporter without a device. In Go tests a device is served with
+// httptest:
+//
+//	device, _ := simulator.NewDevice("Pearl Mini")
+//	sim := simulator.New(device)
+//	server := httptest.NewServer(sim)
+//	defer server.Close()
+//	sim.SetSignal("D2P0.hdmi-a", false)
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mm-dict/pearl-exporter/prober"
+)
+
+// Device describes the simulated device. Zero values are healthy: sources
+// have a signal, audio is not silent and no faults are injected.
+type Device struct {
+	Product         string `yaml:"product"`
+	Serial          string `yaml:"serial"`
+	Hostname        string `yaml:"hostname"`
+	FirmwareVersion string `yaml:"firmware_version"`
+	// AvailableFirmware is the version offered by firmware update checks,
+	// none if empty.
+	AvailableFirmware string `yaml:"available_firmware"`
+	// Username and Password are required for every request if Username is
+	// set.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	CPULoad        int64 `yaml:"cpu_load"`
+	CPUTemperature int64 `yaml:"cpu_temperature"`
+	// ClockOffset is how far the clock of the device is ahead.
+	ClockOffset time.Duration `yaml:"clock_offset"`
+
+	Storages  []Storage  `yaml:"storages"`
+	Sources   []Source   `yaml:"sources"`
+	Channels  []Channel  `yaml:"channels"`
+	Recorders []Recorder `yaml:"recorders"`
+	// CMS is the content management system integration, disabled if nil.
+	CMS *CMS `yaml:"cms"`
+
+	Faults []Fault `yaml:"faults"`
+}
+
+// Storage is a storage volume. The volume with id "main" is the one reported
+// by devices with a single volume.
+type Storage struct {
+	ID    string `yaml:"id"`
+	Name  string `yaml:"name"`
+	Total int64  `yaml:"total"`
+	Free  int64  `yaml:"free"`
+}
+
+// Source is a video and/or audio input.
+type Source struct {
+	ID         string `yaml:"id"`
+	Name       string `yaml:"name"`
+	Video      bool   `yaml:"video"`
+	Audio      bool   `yaml:"audio"`
+	Resolution string `yaml:"resolution"`
+	Framerate  int    `yaml:"framerate"`
+	Interlaced bool   `yaml:"interlaced"`
+	// NoSignal simulates a disconnected input. Its audio is silent too.
+	NoSignal bool `yaml:"no_signal"`
+	Silent   bool `yaml:"silent"`
+	// AudioChannels is the number of audio channels, 2 if 0.
+	AudioChannels int `yaml:"audio_channels"`
+}
+
+// Channel is an encoder. It loses its signal when its source does.
+type Channel struct {
+	ID         string      `yaml:"id"`
+	Source     string      `yaml:"source"`
+	Codec      string      `yaml:"codec"`
+	Resolution string      `yaml:"resolution"`
+	Framerate  float64     `yaml:"framerate"`
+	Bitrate    int64       `yaml:"bitrate"`
+	Stopped    bool        `yaml:"stopped"`
+	Publishers []Publisher `yaml:"publishers"`
+}
+
+// Publisher streams a channel to a destination.
+type Publisher struct {
+	ID      string `yaml:"id"`
+	Started bool   `yaml:"started"`
+}
+
+// Recorder records one or more channels to the storage.
+type Recorder struct {
+	ID        string `yaml:"id"`
+	Recording bool   `yaml:"recording"`
+}
+
+// CMS is the integration with a content management system.
+type CMS struct {
+	Type  string `yaml:"type"`
+	State string `yaml:"state"`
+}
+
+// Fault breaks the requests with a path starting with Path, or every
+// request if Path is empty. Every fault matching a request applies.
+type Fault struct {
+	Path string `yaml:"path"`
+	// Latency delays the response.
+	Latency time.Duration `yaml:"latency"`
+	// Status answers with this HTTP status, e.g. 401 or 500, instead of
+	// the response.
+	Status int `yaml:"status"`
+	// Malformed truncates the JSON of the response.
+	Malformed bool `yaml:"malformed"`
+}
+
+// Models returns the names of the models NewDevice knows.
+func Models() []string {
+	models := make([]string, 0, len(prober.ModelProfiles))
+	for _, profile := range prober.ModelProfiles {
+		models = append(models, profile.Name)
+	}
+	return models
+}
+
+// NewDevice returns a healthy device of the given model with two channels,
+// recording if the model has recorders.
+func NewDevice(model string) (Device, error) {
+	var profile *prober.ModelProfile
+	for i := range prober.ModelProfiles {
+		if strings.EqualFold(prober.ModelProfiles[i].Name, model) {
+			profile = &prober.ModelProfiles[i]
+		}
+	}
+	if profile == nil {
+		return Device{}, fmt.Errorf("unknown model %q, known models are %s", model, strings.Join(Models(), ", "))
+	}
+
+	d := Device{
+		Product:         profile.Name,
+		Serial:          "SIM0001",
+		Hostname:        "pearl-simulator",
+		FirmwareVersion: "4.14.2",
+		CPULoad:         23,
+		CPUTemperature:  52,
+	}
+	var videoSources []string
+	for _, s := range profile.Sources {
+		source := Source{ID: s.Id, Name: s.Name, Video: s.Video, Audio: s.Audio}
+		if s.Video {
+			source.Resolution = "1920x1080"
+			source.Framerate = 30
+			videoSources = append(videoSources, s.Id)
+		}
+		d.Sources = append(d.Sources, source)
+	}
+	for i := 0; i < 2 && i < len(videoSources); i++ {
+		d.Channels = append(d.Channels, Channel{
+			ID:         fmt.Sprint(i + 1),
+			Source:     videoSources[i],
+			Codec:      "H.264",
+			Resolution: "1920x1080",
+			Framerate:  30,
+			Bitrate:    6000,
+			Publishers: []Publisher{{ID: "1", Started: true}},
+		})
+	}
+	if profile.HasCollector("recorders") {
+		d.Recorders = []Recorder{{ID: "1", Recording: true}, {ID: "2"}}
+	}
+	if profile.HasCollector("storage") {
+		d.Storages = append(d.Storages, Storage{ID: "main", Name: "Internal", Total: 1000204886016, Free: 734003200000})
+	}
+	if profile.HasCollector("storages") {
+		d.Storages = append(d.Storages, Storage{ID: "usb", Name: "USB", Total: 256060514304, Free: 200000000000})
+	}
+	return d, nil
+}
+
+func (d *Device) source(id string) *Source {
+	for i := range d.Sources {
+		if d.Sources[i].ID == id {
+			return &d.Sources[i]
+		}
+	}
+	return nil
+}
+
+func (d *Device) channel(id string) *Channel {
+	for i := range d.Channels {
+		if d.Channels[i].ID == id {
+			return &d.Channels[i]
+		}
+	}
+	return nil
+}
+
+func (d *Device) recorder(id string) *Recorder {
+	for i := range d.Recorders {
+		if d.Recorders[i].ID == id {
+			return &d.Recorders[i]
+		}
+	}
+	return nil
+}
+
+// signal reports whether the channel has a video signal.
+func (d *Device) signal(c *Channel) bool {
+	s := d.source(c.Source)
+	return s != nil && !s.NoSignal
+}
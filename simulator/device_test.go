@@ -0,0 +1,85 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package simulator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewDevice(t *testing.T) {
+	tests := []struct {
+		model     string
+		product   string
+		channels  int
+		recorders int
+		storages  []string
+	}{
+		{"Pearl Mini", "Pearl Mini", 2, 2, []string{"main"}},
+		{"pearl-2", "Pearl-2", 2, 2, []string{"main"}},
+		{"PEARL NEXUS", "Pearl Nexus", 2, 2, []string{"main", "usb"}},
+		// The EC20 has a single camera and nothing to record with.
+		{"EC20", "EC20", 1, 0, nil},
+	}
+	for _, test := range tests {
+		d, err := NewDevice(test.model)
+		if err != nil {
+			t.Errorf("NewDevice(%q) failed: %s", test.model, err)
+			continue
+		}
+		if d.Product != test.product {
+			t.Errorf("NewDevice(%q).Product = %q, want %q", test.model, d.Product, test.product)
+		}
+		if len(d.Channels) != test.channels {
+			t.Errorf("NewDevice(%q) has %d channels, want %d", test.model, len(d.Channels), test.channels)
+		}
+		for _, c := range d.Channels {
+			if s := d.source(c.Source); s == nil || !s.Video {
+				t.Errorf("NewDevice(%q) channel %s encodes %q, want a video source", test.model, c.ID, c.Source)
+			}
+		}
+		if len(d.Recorders) != test.recorders {
+			t.Errorf("NewDevice(%q) has %d recorders, want %d", test.model, len(d.Recorders), test.recorders)
+		}
+		var storages []string
+		for _, s := range d.Storages {
+			storages = append(storages, s.ID)
+		}
+		if !reflect.DeepEqual(storages, test.storages) {
+			t.Errorf("NewDevice(%q) has storages %q, want %q", test.model, storages, test.storages)
+		}
+	}
+}
+
+func TestNewDeviceUnknownModel(t *testing.T) {
+	_, err := NewDevice("Pearl-3")
+	if err == nil {
+		t.Fatal("NewDevice(\"Pearl-3\") succeeded, want an error")
+	}
+	for _, model := range Models() {
+		if !strings.Contains(err.Error(), model) {
+			t.Errorf("error %q does not list the known model %q", err, model)
+		}
+	}
+}
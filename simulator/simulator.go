@@ -0,0 +1,406 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package simulator
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mm-dict/pearl-exporter/prober"
+)
+
+// Simulator serves the REST API of a simulated device. It is safe for
+// concurrent use, the device may be changed while it is served.
+type Simulator struct {
+	mtx      sync.Mutex
+	device   Device
+	requests int64
+	started  time.Time
+}
+
+// New returns a simulator serving the given device.
+func New(d Device) *Simulator {
+	return &Simulator{device: d, started: time.Now()}
+}
+
+// Update changes the device under the lock of the simulator, e.g. to inject
+// faults while it is served.
+func (s *Simulator) Update(f func(d *Device)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	f(&s.device)
+}
+
+// SetSignal connects or disconnects the source with the given id.
+func (s *Simulator) SetSignal(id string, signal bool) {
+	s.Update(func(d *Device) {
+		if source := d.source(id); source != nil {
+			source.NoSignal = !signal
+		}
+	})
+}
+
+// Device returns a copy of the simulated device.
+func (s *Simulator) Device() Device {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.device
+}
+
+// apiError is answered with an HTTP error status.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	s.requests++
+	// The faults matching the request add up, the first status wins.
+	var fault Fault
+	for _, f := range s.device.Faults {
+		if strings.HasPrefix(r.URL.Path, f.Path) {
+			fault.Latency += f.Latency
+			if fault.Status == 0 {
+				fault.Status = f.Status
+			}
+			fault.Malformed = fault.Malformed || f.Malformed
+		}
+	}
+	username, password := s.device.Username, s.device.Password
+	s.mtx.Unlock()
+
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if user, pass, _ := r.BasicAuth(); username != "" && (user != username || pass != password) {
+		writeError(w, &apiError{http.StatusUnauthorized, "Unauthorized"})
+		return
+	}
+	if fault.Status != 0 {
+		writeError(w, &apiError{fault.Status, http.StatusText(fault.Status)})
+		return
+	}
+
+	s.mtx.Lock()
+	result, err := s.handle(r)
+	s.mtx.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if img, ok := result.(image.Image); ok {
+		w.Header().Set("Content-Type", "image/jpeg")
+		jpeg.Encode(w, img, nil)
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{"status": "ok", "result": result})
+	if fault.Malformed {
+		body = body[:len(body)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	body, _ := json.Marshal(map[string]string{"status": "error", "message": err.message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	w.Write(body)
+}
+
+var errNotFound = &apiError{http.StatusNotFound, "Not found"}
+
+// handle returns the result of the request, which is encoded as JSON unless
+// it is an image. It is called with the lock held.
+func (s *Simulator) handle(r *http.Request) (interface{}, *apiError) {
+	d := &s.device
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "api" {
+		return nil, errNotFound
+	}
+	route := r.Method + " " + strings.Join(parts[1:], "/")
+
+	switch {
+	case route == "GET system/firmware/version":
+		return d.FirmwareVersion, nil
+	case route == "GET system/info":
+		return map[string]interface{}{"product": d.Product, "serial": d.Serial, "hostname": d.Hostname}, nil
+	case route == "POST system/firmware/update/control/check":
+		if d.AvailableFirmware == "" {
+			return map[string]interface{}{"status": "uptodate", "changed": false}, nil
+		}
+		return map[string]interface{}{"status": "available", "version": d.AvailableFirmware, "changed": false}, nil
+	case route == "GET system/status":
+		status := map[string]interface{}{
+			"date":    time.Now().Add(d.ClockOffset).UTC().Format("2006-01-02T15:04:05Z07:00"),
+			"uptime":  int64(time.Since(s.started).Seconds()),
+			"cpuload": d.CPULoad,
+			"cputemp": d.CPUTemperature,
+		}
+		// Firmware before 4.14 names the CPU load alarm differently.
+		if prober.CompareFirmwareVersions(d.FirmwareVersion, "4.14") < 0 {
+			status["cpuload_high"] = d.CPULoad > 90
+		} else {
+			status["cpuloadhigh"] = d.CPULoad > 90
+		}
+		return status, nil
+	case route == "GET system/sensors":
+		return []map[string]interface{}{
+			{"id": "board", "name": "Mainboard", "type": "temperature", "value": float64(d.CPUTemperature) - 12},
+			{"id": "fan1", "name": "Fan", "type": "fan", "value": 2400},
+		}, nil
+	case route == "GET system/datetime":
+		return map[string]interface{}{
+			"timezone": "UTC",
+			"ntp":      map[string]interface{}{"enabled": true, "server": "pool.ntp.org", "synchronized": d.ClockOffset == 0},
+		}, nil
+	case route == "GET system/network/status":
+		// The counters grow with every request, like on a busy device.
+		return []map[string]interface{}{{
+			"id":        "eth0",
+			"link":      true,
+			"speed":     1000,
+			"duplex":    "full",
+			"dhcp":      true,
+			"ipv4":      map[string]string{"address": "192.0.2.10", "netmask": "255.255.255.0", "gateway": "192.0.2.1"},
+			"rx_bytes":  s.requests * 1500,
+			"tx_bytes":  s.requests * 750000,
+			"rx_errors": 0,
+			"tx_errors": 0,
+		}}, nil
+	case route == "GET system/storages/status":
+		if len(d.Storages) == 0 {
+			return nil, errNotFound
+		}
+		storages := []map[string]interface{}{}
+		for _, st := range d.Storages {
+			storages = append(storages, map[string]interface{}{"id": st.ID, "name": st.Name, "state": "ready", "total": st.Total, "free": st.Free})
+		}
+		return storages, nil
+	case len(parts) == 5 && route == "GET system/storages/"+parts[3]+"/status":
+		for _, st := range d.Storages {
+			if st.ID == parts[3] {
+				return map[string]interface{}{"state": "ready", "total": st.Total, "free": st.Free}, nil
+			}
+		}
+		return nil, errNotFound
+	case route == "POST system/control/reboot":
+		s.started = time.Now()
+		return "rebooting", nil
+	case route == "GET cms/status":
+		if d.CMS == nil {
+			return map[string]interface{}{"enabled": false, "type": "", "state": "disabled"}, nil
+		}
+		return map[string]interface{}{"enabled": true, "type": d.CMS.Type, "state": d.CMS.State, "last_sync": s.started.UTC().Format(time.RFC3339)}, nil
+	case route == "GET cms/events":
+		return []interface{}{}, nil
+	case route == "GET recorders/status":
+		recorders := []map[string]interface{}{}
+		for _, rec := range d.Recorders {
+			state := "stopped"
+			if rec.Recording {
+				state = "started"
+			}
+			recorders = append(recorders, map[string]interface{}{"id": rec.ID, "status": map[string]interface{}{"state": state}})
+		}
+		return recorders, nil
+	case len(parts) == 5 && route == "POST recorders/"+parts[2]+"/control/"+parts[4]:
+		rec := d.recorder(parts[2])
+		if rec == nil {
+			return nil, errNotFound
+		}
+		switch parts[4] {
+		case "start":
+			rec.Recording = true
+		case "stop":
+			rec.Recording = false
+		default:
+			return nil, &apiError{http.StatusBadRequest, "Unknown action"}
+		}
+		return "done", nil
+	case route == "GET channels/status":
+		return s.channelStatus(), nil
+	case len(parts) == 4 && route == "GET channels/"+parts[2]+"/encoding":
+		c := d.channel(parts[2])
+		if c == nil {
+			return nil, errNotFound
+		}
+		return map[string]interface{}{"codec": c.Codec, "resolution": c.Resolution, "framerate": c.Framerate, "bitrate": c.Bitrate}, nil
+	case len(parts) == 4 && route == "GET channels/"+parts[2]+"/layouts":
+		if d.channel(parts[2]) == nil {
+			return nil, errNotFound
+		}
+		return []map[string]interface{}{{"id": "1", "name": "Default", "active": true}}, nil
+	case len(parts) == 4 && route == "GET channels/"+parts[2]+"/preview":
+		c := d.channel(parts[2])
+		if c == nil {
+			return nil, errNotFound
+		}
+		return s.preview(c), nil
+	case len(parts) == 7 && route == "POST channels/"+parts[2]+"/publishers/"+parts[4]+"/control/"+parts[6]:
+		c := d.channel(parts[2])
+		if c == nil {
+			return nil, errNotFound
+		}
+		for i := range c.Publishers {
+			if c.Publishers[i].ID == parts[4] {
+				switch parts[6] {
+				case "start":
+					c.Publishers[i].Started = true
+				case "stop":
+					c.Publishers[i].Started = false
+				default:
+					return nil, &apiError{http.StatusBadRequest, "Unknown action"}
+				}
+				return "done", nil
+			}
+		}
+		return nil, errNotFound
+	case route == "GET sources":
+		sources := []map[string]interface{}{}
+		for _, src := range d.Sources {
+			sources = append(sources, map[string]interface{}{"id": src.ID, "name": src.Name, "video": src.Video, "audio": src.Audio})
+		}
+		return sources, nil
+	case route == "GET sources/status":
+		return s.sourceStatus(r.URL.Query().Get("ids")), nil
+	case len(parts) == 4 && route == "GET sources/"+parts[2]+"/audiolevels":
+		src := d.source(parts[2])
+		if src == nil || !src.Audio {
+			return nil, errNotFound
+		}
+		channels := src.AudioChannels
+		if channels == 0 {
+			channels = 2
+		}
+		peak, rms := make([]float64, channels), make([]float64, channels)
+		for i := range peak {
+			peak[i], rms[i] = -12-float64(i), -20-float64(i)
+			if src.Silent || src.NoSignal {
+				peak[i], rms[i] = -90, -96
+			}
+		}
+		return map[string]interface{}{"peak": peak, "rms": rms}, nil
+	}
+	return nil, errNotFound
+}
+
+func (s *Simulator) channelStatus() interface{} {
+	d := &s.device
+	uptime := time.Since(s.started).Seconds()
+	channels := []map[string]interface{}{}
+	for i := range d.Channels {
+		c := &d.Channels[i]
+		status := map[string]interface{}{"state": "started", "nosignal": 0, "bitrate": c.Bitrate, "duration": int64(uptime)}
+		if c.Stopped {
+			status = map[string]interface{}{"state": "stopped", "nosignal": 0, "bitrate": 0, "duration": 0}
+		} else if !d.signal(c) {
+			status["nosignal"] = 1
+			status["bitrate"] = 0
+		}
+		publishers := []map[string]interface{}{}
+		for _, p := range c.Publishers {
+			state := "stopped"
+			if p.Started && !c.Stopped {
+				state = "started"
+			}
+			publishers = append(publishers, map[string]interface{}{
+				"id":     p.ID,
+				"status": map[string]interface{}{"isconfigured": true, "started": p.Started, "state": state, "duration": int64(uptime)},
+			})
+		}
+		channels = append(channels, map[string]interface{}{"id": c.ID, "status": status, "publishers": publishers})
+	}
+	return channels
+}
+
+// sourceStatus returns the status of the sources with the given comma
+// separated ids, or of every source if ids is empty.
+func (s *Simulator) sourceStatus(ids string) interface{} {
+	sources := []map[string]interface{}{}
+	for _, src := range s.device.Sources {
+		if ids != "" && !contains(strings.Split(ids, ","), src.ID) {
+			continue
+		}
+		status := map[string]interface{}{}
+		if src.Video {
+			video := map[string]interface{}{"state": "active", "resolution": src.Resolution, "actual_fps": src.Framerate, "interlaced": src.Interlaced, "vrr": src.Framerate}
+			if src.NoSignal {
+				video = map[string]interface{}{"state": "no signal", "resolution": "", "actual_fps": 0, "interlaced": false, "vrr": 0}
+			}
+			status["video"] = video
+		}
+		if src.Audio {
+			channels := src.AudioChannels
+			if channels == 0 {
+				channels = 2
+			}
+			state := "active"
+			if src.NoSignal {
+				state = "no signal"
+			}
+			status["audio"] = map[string]interface{}{"state": state, "channels": channels}
+		}
+		sources = append(sources, map[string]interface{}{"id": src.ID, "name": src.Name, "status": status})
+	}
+	return sources
+}
+
+// preview returns a picture of the channel: black without a signal and a bar
+// moving with every request otherwise, so it is never frozen.
+func (s *Simulator) preview(c *Channel) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 160, 90))
+	if !s.device.signal(c) {
+		return img
+	}
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	bar := int(s.requests*8) % img.Bounds().Dx()
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := bar; x < bar+16 && x < img.Bounds().Dx(); x++ {
+			img.SetGray(x, y, color.Gray{Y: 0xff})
+		}
+	}
+	return img
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
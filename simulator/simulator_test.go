@@ -0,0 +1,260 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package simulator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// request sends a request to the simulator and returns the status code and
+// the body of the response.
+func request(t *testing.T, server *httptest.Server, method string, path string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("admin", "secret")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// result decodes the result of a successful response.
+func result(t *testing.T, body string) interface{} {
+	t.Helper()
+	var response struct {
+		Status string
+		Result interface{}
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("invalid response %q: %s", body, err)
+	}
+	if response.Status != "ok" {
+		t.Fatalf("response %q has status %q, want ok", body, response.Status)
+	}
+	return response.Result
+}
+
+func newTestServer(t *testing.T, model string) (*Simulator, *httptest.Server) {
+	t.Helper()
+	d, err := NewDevice(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Username, d.Password = "admin", "secret"
+	sim := New(d)
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return sim, server
+}
+
+func TestServeHTTP(t *testing.T) {
+	_, server := newTestServer(t, "Pearl Nexus")
+	tests := []struct {
+		method string
+		path   string
+		status int
+		want   string
+	}{
+		{"GET", "/api/system/firmware/version", 200, `"result":"4.14.2"`},
+		{"GET", "/api/system/info", 200, `"product":"Pearl Nexus"`},
+		{"POST", "/api/system/firmware/update/control/check", 200, `"status":"uptodate"`},
+		{"GET", "/api/system/storages/main/status", 200, `"total":1000204886016`},
+		{"GET", "/api/system/storages/usb/status", 200, `"free":200000000000`},
+		{"GET", "/api/system/storages/status", 200, `"id":"usb"`},
+		{"GET", "/api/system/storages/sd/status", 404, `"status":"error"`},
+		{"GET", "/api/cms/status", 200, `"enabled":false`},
+		{"GET", "/api/channels/1/encoding", 200, `"bitrate":6000`},
+		{"GET", "/api/channels/9/encoding", 404, `"status":"error"`},
+		{"GET", "/api/sources/status?ids=D2P0.sdi", 200, `"id":"D2P0.sdi"`},
+		{"GET", "/api/sources/D2P0.analog-a/audiolevels", 200, `"peak":[-12,-13]`},
+		{"POST", "/api/recorders/1/control/pause", 400, `"message":"Unknown action"`},
+		{"POST", "/api/recorders/9/control/start", 404, `"status":"error"`},
+		{"POST", "/api/channels/1/publishers/9/control/start", 404, `"status":"error"`},
+		// The method is part of the route.
+		{"POST", "/api/system/info", 404, `"status":"error"`},
+		{"GET", "/api/unknown", 404, `"message":"Not found"`},
+		{"GET", "/index.html", 404, `"message":"Not found"`},
+	}
+	for _, test := range tests {
+		status, body := request(t, server, test.method, test.path)
+		if status != test.status || !strings.Contains(body, test.want) {
+			t.Errorf("%s %s = %d %s, want %d with %s", test.method, test.path, status, body, test.status, test.want)
+		}
+	}
+}
+
+func TestServeHTTPWithoutStorage(t *testing.T) {
+	_, server := newTestServer(t, "EC20")
+	for _, path := range []string{"/api/system/storages/status", "/api/system/storages/main/status", "/api/sources/EC20.camera/audiolevels"} {
+		if status, body := request(t, server, "GET", path); status != http.StatusNotFound {
+			t.Errorf("GET %s = %d %s, want 404", path, status, body)
+		}
+	}
+}
+
+func TestSystemStatusCpuLoadHigh(t *testing.T) {
+	tests := []struct {
+		firmware string
+		cpuLoad  int64
+		field    string
+		high     bool
+	}{
+		{"4.12.1", 95, "cpuload_high", true},
+		{"4.13.9", 90, "cpuload_high", false},
+		{"4.14", 91, "cpuloadhigh", true},
+		{"4.15.3", 20, "cpuloadhigh", false},
+	}
+	for _, test := range tests {
+		sim, server := newTestServer(t, "Pearl Mini")
+		sim.Update(func(d *Device) {
+			d.FirmwareVersion = test.firmware
+			d.CPULoad = test.cpuLoad
+		})
+		_, body := request(t, server, "GET", "/api/system/status")
+		status := result(t, body).(map[string]interface{})
+		if high, ok := status[test.field]; !ok || high != test.high {
+			t.Errorf("status of firmware %s with CPU load %d has %s = %v, want %t: %s", test.firmware, test.cpuLoad, test.field, high, test.high, body)
+		}
+		other := "cpuloadhigh"
+		if test.field == other {
+			other = "cpuload_high"
+		}
+		if _, ok := status[other]; ok {
+			t.Errorf("status of firmware %s has %s, want only %s", test.firmware, other, test.field)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults []Fault
+		path   string
+		status int
+		valid  bool
+	}{
+		{"none", nil, "/api/system/info", 200, true},
+		{"status", []Fault{{Path: "/api/system", Status: 500}}, "/api/system/info", 500, true},
+		{"other path", []Fault{{Path: "/api/channels", Status: 500}}, "/api/system/info", 200, true},
+		{"every path", []Fault{{Status: 503}}, "/api/system/info", 503, true},
+		// The first matching status wins.
+		{"first status", []Fault{{Status: 502}, {Path: "/api/system", Status: 500}}, "/api/system/info", 502, true},
+		{"malformed", []Fault{{Path: "/api/system/info", Malformed: true}}, "/api/system/info", 200, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim, server := newTestServer(t, "Pearl Mini")
+			sim.Update(func(d *Device) {
+				d.Faults = test.faults
+			})
+			status, body := request(t, server, "GET", test.path)
+			if status != test.status {
+				t.Errorf("GET %s = %d, want %d", test.path, status, test.status)
+			}
+			if valid := json.Valid([]byte(body)); valid != test.valid {
+				t.Errorf("GET %s returned valid JSON %t, want %t: %s", test.path, valid, test.valid, body)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	_, server := newTestServer(t, "Pearl Mini")
+	for _, user := range []string{"", "admin:wrong", "other:secret"} {
+		req, err := http.NewRequest("GET", server.URL+"/api/system/info", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, password, ok := strings.Cut(user, ":"); ok {
+			req.SetBasicAuth(name, password)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("request as %q = %d, want 401", user, resp.StatusCode)
+		}
+	}
+}
+
+func TestSetSignal(t *testing.T) {
+	sim, server := newTestServer(t, "Pearl Mini")
+	sim.SetSignal("D2P0.hdmi-a", false)
+
+	_, body := request(t, server, "GET", "/api/channels/status")
+	for _, c := range result(t, body).([]interface{}) {
+		channel := c.(map[string]interface{})
+		status := channel["status"].(map[string]interface{})
+		// Channel 1 encodes HDMI-A, channel 2 HDMI-B.
+		wantNoSignal := float64(0)
+		if channel["id"] == "1" {
+			wantNoSignal = 1
+		}
+		if status["nosignal"] != wantNoSignal {
+			t.Errorf("channel %s has nosignal %v, want %v", channel["id"], status["nosignal"], wantNoSignal)
+		}
+	}
+	if _, body := request(t, server, "GET", "/api/sources/status?ids=D2P0.hdmi-a"); !strings.Contains(body, `"state":"no signal"`) {
+		t.Errorf("status of the disconnected source = %s, want no signal", body)
+	}
+	if _, body := request(t, server, "GET", "/api/sources/D2P0.hdmi-a/audiolevels"); !strings.Contains(body, `"peak":[-90,-90]`) {
+		t.Errorf("audio levels of the disconnected source = %s, want silence", body)
+	}
+
+	sim.SetSignal("D2P0.hdmi-a", true)
+	if _, body := request(t, server, "GET", "/api/sources/status?ids=D2P0.hdmi-a"); !strings.Contains(body, `"state":"active"`) {
+		t.Errorf("status of the reconnected source = %s, want active", body)
+	}
+}
+
+func TestControl(t *testing.T) {
+	sim, server := newTestServer(t, "Pearl Mini")
+	for _, path := range []string{"/api/recorders/1/control/stop", "/api/recorders/2/control/start", "/api/channels/2/publishers/1/control/stop"} {
+		if status, body := request(t, server, "POST", path); status != http.StatusOK {
+			t.Fatalf("POST %s = %d %s, want 200", path, status, body)
+		}
+	}
+	d := sim.Device()
+	if d.Recorders[0].Recording || !d.Recorders[1].Recording {
+		t.Errorf("recorders are %+v, want only recorder 2 recording", d.Recorders)
+	}
+	if !d.Channels[0].Publishers[0].Started || d.Channels[1].Publishers[0].Started {
+		t.Errorf("publishers of channel 1 %+v and 2 %+v, want only channel 2 stopped", d.Channels[0].Publishers, d.Channels[1].Publishers)
+	}
+}
@@ -0,0 +1,195 @@
+// MIT License
+
+// Copyright (c) 2022 Kristof Keppens <kristof.keppens@ugent.be>
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mm-dict/pearl-exporter/config"
+	"github.com/mm-dict/pearl-exporter/prober"
+)
+
+var (
+	webhookActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pearl_exporter",
+		Name:      "webhook_actions_total",
+		Help:      "Number of recorder actions taken for Alertmanager notifications, by action and result",
+	}, []string{"action", "result"})
+	webhookAlertsIgnored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pearl_exporter",
+		Name:      "webhook_alerts_ignored_total",
+		Help:      "Number of alerts received on the Alertmanager webhook that no action was taken for, by reason",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(webhookActions)
+	prometheus.MustRegister(webhookAlertsIgnored)
+}
+
+// alertmanagerMessage is the payload of an Alertmanager webhook notification.
+type alertmanagerMessage struct {
+	Version string              `json:"version"`
+	Status  string              `json:"status"`
+	Alerts  []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// maxWebhookRequestBytes limits the body of Alertmanager notifications, which
+// may hold many alerts.
+const maxWebhookRequestBytes = 4 << 20
+
+// webhookHandler receives Alertmanager notifications and starts or stops the
+// recorder named in every alert configured for it. Notifications must be
+// authenticated and every action, including those of a dry run, is written
+// to the audit log. No action is sent unless it could be audited. Failed
+// authentications are collapsed in the audit log by authFailures.
+func webhookHandler(w http.ResponseWriter, r *http.Request, c *config.Config, logger log.Logger) {
+	if !c.Webhook.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "This endpoint requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	remoteAddr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteAddr = host
+	}
+	audit := func(entry auditEntry) error {
+		entry.Time = time.Now()
+		entry.RemoteAddr = remoteAddr
+		err := controlAudit.write(c.Webhook.AuditLog, entry)
+		if err != nil {
+			level.Error(logger).Log("msg", "Unable to write audit log", "file", c.Webhook.AuditLog, "err", err)
+		}
+		return err
+	}
+
+	if !c.Webhook.Authenticate(r) {
+		if ok, suppressed := webhookAuthFailures.record(remoteAddr, time.Now()); ok {
+			audit(auditEntry{Result: "unauthenticated", Error: "missing or wrong webhook credentials", Suppressed: suppressed})
+			level.Warn(logger).Log("msg", "Rejecting unauthenticated webhook notification", "audit", "webhook", "remote_addr", remoteAddr, "suppressed", suppressed)
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Missing or wrong webhook credentials", http.StatusUnauthorized)
+		return
+	}
+	var msg alertmanagerMessage
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid Alertmanager notification: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	failed := 0
+	for _, alert := range msg.Alerts {
+		alertname := alert.Labels["alertname"]
+		actions := c.Webhook.Alert(alertname)
+		if actions == nil {
+			webhookAlertsIgnored.WithLabelValues("unknown_alert").Inc()
+			continue
+		}
+		action := actions.Firing
+		if alert.Status == "resolved" {
+			action = actions.Resolved
+		}
+		if action == "" {
+			webhookAlertsIgnored.WithLabelValues("no_action").Inc()
+			continue
+		}
+		target := alert.Labels[c.Webhook.TargetLabel]
+		recorder := alert.Labels[c.Webhook.RecorderLabel]
+		entry := auditEntry{
+			controlRequest: controlRequest{Target: target, Action: "recorder_" + action, Recorder: recorder},
+			Alertname:      alertname,
+			Fingerprint:    alert.Fingerprint,
+		}
+		auditLogger := log.With(logger, "audit", "webhook", "alertname", alertname, "fingerprint", alert.Fingerprint, "target", target, "recorder", recorder, "action", action, "dry_run", c.Webhook.DryRun)
+		if target == "" || recorder == "" {
+			webhookAlertsIgnored.WithLabelValues("missing_label").Inc()
+			entry.Result, entry.Error = "invalid", "alert without target or recorder label"
+			audit(entry)
+			level.Warn(auditLogger).Log("msg", "Ignoring alert without target or recorder label")
+			continue
+		}
+		allowed, err := c.WebhookTargetAllowed(target)
+		if err != nil || !allowed {
+			webhookAlertsIgnored.WithLabelValues("not_allowed").Inc()
+			entry.Result, entry.Error = "forbidden", fmt.Sprintf("target %q is not allowed for the webhook", target)
+			audit(entry)
+			level.Warn(auditLogger).Log("msg", "Ignoring alert for target not allowed for the webhook", "err", err)
+			continue
+		}
+
+		if c.Webhook.DryRun {
+			webhookActions.WithLabelValues(action, "dry_run").Inc()
+			entry.Result = "dry_run"
+			audit(entry)
+			level.Info(auditLogger).Log("msg", "Dry run, not sending recorder action")
+			continue
+		}
+		entry.Result = "started"
+		if err := audit(entry); err != nil {
+			failed++
+			webhookActions.WithLabelValues(action, "failed").Inc()
+			level.Error(auditLogger).Log("msg", "Unable to write audit log, not sending recorder action", "err", err)
+			continue
+		}
+		user, password := c.Credentials(target)
+		if err := prober.ControlRecorder(target, user, password, recorder, action); err != nil {
+			failed++
+			webhookActions.WithLabelValues(action, "failed").Inc()
+			entry.Result, entry.Error = "failed", err.Error()
+			audit(entry)
+			level.Error(auditLogger).Log("msg", "Recorder action failed", "err", err)
+			continue
+		}
+		webhookActions.WithLabelValues(action, "success").Inc()
+		entry.Result = "success"
+		audit(entry)
+		level.Info(auditLogger).Log("msg", "Recorder action sent")
+	}
+
+	// Alertmanager retries notifications that fail with a server error.
+	if failed > 0 {
+		http.Error(w, fmt.Sprintf("%d recorder actions failed", failed), http.StatusBadGateway)
+	}
+}